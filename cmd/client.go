@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	s "strings"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/resources"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+// kubeConfigFlags are the standard kubectl flags: --kubeconfig, --context, --namespace, --as, --request-timeout ...
+var kubeConfigFlags = genericclioptions.NewConfigFlags(true)
+
+// clients bundles what the subcommands need to talk to a cluster
+type clients struct {
+	dynamic   dynamic.Interface
+	discovery discovery.DiscoveryInterface
+	resolver  *resources.Resolver
+	// namespace is the one set by --namespace or the kubeconfig context
+	namespace string
+}
+
+// newClients builds the clients for the cluster selected by the flags. tests replace it to use fakes
+var newClients = func(flags *genericclioptions.ConfigFlags) (*clients, error) {
+	restConfig, err := flags.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	dyn, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	disc, err := flags.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := resources.FromDiscovery(disc)
+	if err != nil {
+		log.Warnf("error discovering API resources, using the built-in ones : %v", err)
+		resolver = resources.Builtin()
+	}
+	ns, _, err := flags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return nil, err
+	}
+	return &clients{dynamic: dyn, discovery: disc, resolver: resolver, namespace: ns}, nil
+}
+
+// flagsForContext returns a copy of the kubeconfig flags pointing at another context
+func flagsForContext(flags *genericclioptions.ConfigFlags, kubeContext string) *genericclioptions.ConfigFlags {
+	return &genericclioptions.ConfigFlags{
+		CacheDir:           flags.CacheDir,
+		KubeConfig:         flags.KubeConfig,
+		ClusterName:        flags.ClusterName,
+		AuthInfoName:       flags.AuthInfoName,
+		Context:            &kubeContext,
+		Namespace:          flags.Namespace,
+		APIServer:          flags.APIServer,
+		TLSServerName:      flags.TLSServerName,
+		Insecure:           flags.Insecure,
+		CertFile:           flags.CertFile,
+		KeyFile:            flags.KeyFile,
+		CAFile:             flags.CAFile,
+		BearerToken:        flags.BearerToken,
+		Impersonate:        flags.Impersonate,
+		ImpersonateUID:     flags.ImpersonateUID,
+		ImpersonateGroup:   flags.ImpersonateGroup,
+		Username:           flags.Username,
+		Password:           flags.Password,
+		Timeout:            flags.Timeout,
+		DisableCompression: flags.DisableCompression,
+	}
+}
+
+// cmdContext returns the context of a running command
+func cmdContext(cmd *cobra.Command) context.Context {
+	if ctx := cmd.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// resourceFor resolves a resource argument as kubectl takes it (pods, po, pod, Pod, deployments.apps, deployments.v1.apps)
+func (c *clients) resourceFor(arg string) (resources.Resource, error) {
+	return c.resolver.Resolve(arg)
+}
+
+// resourceInterface returns the dynamic client of a resource, in namespace 'ns' if it's namespaced
+func (c *clients) resourceInterface(r resources.Resource, ns string) dynamic.ResourceInterface {
+	if r.Namespaced {
+		return c.dynamic.Resource(r.GroupVersionResource).Namespace(ns)
+	}
+	return c.dynamic.Resource(r.GroupVersionResource)
+}
+
+// getJSON fetches a single object as json
+func (c *clients) getJSON(ctx context.Context, r resources.Resource, ns string, name string) ([]byte, error) {
+	obj, err := c.resourceInterface(r, ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return obj.MarshalJSON()
+}
+
+// listNames lists the names of the objects of a resource in namespace 'ns'
+func (c *clients) listNames(ctx context.Context, r resources.Resource, ns string) ([]string, error) {
+	list, err := c.resourceInterface(r, ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	return names, nil
+}
+
+// listItems lists the objects of a resource matching 'opts' in namespace 'ns', or in all namespaces if 'ns' is empty
+func (c *clients) listItems(ctx context.Context, r resources.Resource, ns string, opts metav1.ListOptions) ([]unstructured.Unstructured, error) {
+	list, err := c.resourceInterface(r, ns).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		// list items don't carry their kind
+		list.Items[i].SetGroupVersionKind(r.GroupVersionKind())
+	}
+	return list.Items, nil
+}
+
+// listJSON wraps objects in a v1 List, the way kubectl prints several objects
+func listJSON(items []unstructured.Unstructured) ([]byte, error) {
+	var raw []json.RawMessage
+	for _, item := range items {
+		b, err := item.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, b)
+	}
+	if raw == nil {
+		raw = []json.RawMessage{}
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"metadata":   map[string]interface{}{"resourceVersion": ""},
+		"items":      raw,
+	})
+}
+
+// namespaces returns the comma separated namespaces of --namespace, or the namespace of the kubeconfig context
+func (c *clients) namespaces() []string {
+	if kubeConfigFlags.Namespace != nil && *kubeConfigFlags.Namespace != "" {
+		return s.Split(*kubeConfigFlags.Namespace, ",")
+	}
+	return []string{c.namespace}
+}
+
+// getAllNamespaces lists the names of all namespaces
+func (c *clients) getAllNamespaces(ctx context.Context) ([]string, error) {
+	r, err := c.resourceFor("namespaces")
+	if err != nil {
+		return nil, err
+	}
+	return c.listNames(ctx, r, "")
+}
@@ -1,43 +1,170 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
-	"embed"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
-	"path"
 	s "strings"
+	"time"
 	"unicode"
 
+	"github.com/Baiyuani/kubectl-neatx/pkg/defaults"
+	"github.com/Baiyuani/kubectl-neatx/pkg/layout"
+	"github.com/Baiyuani/kubectl-neatx/pkg/metadata"
+	"github.com/Baiyuani/kubectl-neatx/pkg/remap"
+	"github.com/Baiyuani/kubectl-neatx/pkg/rules"
+	"github.com/Baiyuani/kubectl-neatx/pkg/secrets"
 	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
 var outputFormat *string
-var inputFile *string
-var namespace *string
+var inputFiles *[]string
+var recursive *bool
+var inPlace *bool
+var backupSuffix *string
 var exportOutDir *string
 var allNamespaces *bool
-
-//go:embed api-resources.txt
-var folder embed.FS
+var exportConcurrency *int
+var migrateAll *bool
+var migrateAllNamespaces *bool
+var migrateWaitTimeout *time.Duration
+var migrateDryRun *string
+var migrateDiff *bool
+var namespaceMapPairs []string
+var includeOwned bool
+var labelSelector string
+var fieldSelector string
+var allKinds *bool
+var exportLayout *string
+var exportSingleFile *string
+var exportKustomize *bool
+var exportHelmChart *string
+var kustomizeCommonLabels *bool
+var kustomizeGenerators *bool
+var clusterScoped *bool
+var includeKinds *[]string
+var excludeKinds *[]string
+var namespaceMapFile string
+
+var imageRewriteRules []string
+var imageRewriteFile string
+
+var storageClassPairs []string
+var preservePVBinding bool
+
+// storageMap moves exported and migrated volumes and claims to other storage classes
+var storageMap remap.StorageMap
+
+// imageMap rewrites the container images of exported and migrated objects
+var imageMap remap.ImageMap
+
+// namespaceMap moves exported and migrated objects to other namespaces
+var namespaceMap remap.NamespaceMap
+var rulesFiles *[]string
+var noBuiltinRules *bool
+var crdFiles *[]string
+var crdsFromCluster *bool
+
+// stripDefaults makes Neat remove fields holding the value Kubernetes would default them to
+var stripDefaults bool
+
+// metadataPolicy decides which metadata fields Neat keeps
+var metadataPolicy = metadata.DefaultPolicy()
+
+var keepOwnerRefs *bool
+var keepFinalizers *bool
+var keepGenerateName *bool
+var keepMetadata *[]string
+var dropMetadata *[]string
+var flagGenerated *bool
+
+// secretPolicy decides what Neat does to the values of Secrets
+var secretPolicy *secrets.Policy
+
+var secretsMode string
+var ageRecipients []string
+
+// activeRules is the rule set Neat runs, the built-in rules unless changed by flags
+var activeRules = rules.Builtin()
 
 func init() {
 	outputFormat = rootCmd.PersistentFlags().StringP("output", "o", "yaml", "output format: yaml or json")
-	inputFile = rootCmd.Flags().StringP("file", "f", "-", "file path to neat, or - to read from stdin")
-	namespace = exportCmd.Flags().StringP("namespace", "n", "default", "namespace")
+	rulesFiles = rootCmd.PersistentFlags().StringArray("rules", nil, "file with additional neating rules, can be repeated")
+	noBuiltinRules = rootCmd.PersistentFlags().Bool("no-builtin-rules", false, "don't apply the built-in neating rules")
+	rootCmd.PersistentFlags().BoolVar(&stripDefaults, "strip-defaults", false, "remove fields set to their Kubernetes default value")
+	crdFiles = rootCmd.PersistentFlags().StringArray("crd-file", nil, "file with CustomResourceDefinitions whose schema defaults --strip-defaults removes, can be repeated")
+	crdsFromCluster = rootCmd.PersistentFlags().Bool("crds-from-cluster", false, "fetch the CustomResourceDefinitions whose schema defaults --strip-defaults removes from the cluster")
+	keepOwnerRefs = rootCmd.PersistentFlags().Bool("keep-owner-refs", false, "keep metadata.ownerReferences")
+	keepFinalizers = rootCmd.PersistentFlags().Bool("keep-finalizers", false, "keep metadata.finalizers")
+	keepGenerateName = rootCmd.PersistentFlags().Bool("keep-generate-name", false, "keep metadata.generateName, which is only kept for objects without a name by default")
+	keepMetadata = rootCmd.PersistentFlags().StringArray("keep-metadata", nil, "metadata fields to keep as [Kind:]field[,field...], e.g. uid or Job:generateName, can be repeated")
+	dropMetadata = rootCmd.PersistentFlags().StringArray("drop-metadata", nil, "metadata fields to drop as [Kind:]field[,field...], e.g. Secret:annotations, can be repeated")
+	flagGenerated = rootCmd.PersistentFlags().Bool("flag-generated", false, "annotate objects owned by a controller with "+metadata.GeneratedAnnotation)
+	inputFiles = rootCmd.Flags().StringArrayP("file", "f", []string{"-"}, "file, directory or glob to neat, or - to read from stdin, can be repeated")
+	recursive = rootCmd.Flags().BoolP("recursive", "R", false, "neat the files of the subdirectories of -f directories too")
+	inPlace = rootCmd.Flags().Bool("in-place", false, "rewrite the files with their neated content, in the format they're written in")
+	backupSuffix = rootCmd.Flags().String("backup-suffix", "", "with --in-place, keep the original content of each rewritten file in the file name plus this suffix, e.g. .orig")
 	// kindListFromFile = exportCmd.Flags().StringP("list-file", "l", "-", "file path to kind list from file")
 	exportOutDir = exportCmd.Flags().StringP("dest-dir", "d", "manifests", "export file to directory")
 	allNamespaces = exportCmd.Flags().BoolP("all-namespaces", "A", false, "export all namespaces")
+	exportLayout = exportCmd.Flags().String("layout", layout.Default, "where to write each object under the destination directory: by-namespace, by-kind, flat, single-file, or a Go template over .Group .Version .Kind .Resource .Namespace .Name .Ext, e.g. '{{.Namespace}}/{{.Kind | lower}}-{{.Name}}.yaml'")
+	exportSingleFile = exportCmd.Flags().StringP("single-file", "f", "", "write all objects to this file in dependency order, as --- separated documents or a List with -o json. - writes to stdout")
+	exportCmd.MarkFlagFilename("single-file")
+	exportKustomize = exportCmd.Flags().Bool("kustomize", false, "write a kustomization.yaml in each namespace directory, listing its objects and setting its namespace")
+	kustomizeCommonLabels = exportCmd.Flags().Bool("kustomize-common-labels", false, "with --kustomize, move the labels all objects of a namespace have to commonLabels")
+	kustomizeGenerators = exportCmd.Flags().Bool("kustomize-generators", false, "with --kustomize, turn config maps and secrets into configMapGenerator and secretGenerator entries with their data in files")
+	exportHelmChart = exportCmd.Flags().String("helm-chart", "", "write a Helm chart with this name under the destination directory: Chart.yaml, values.yaml with images, replicas, resources and config map data, and the objects as templates")
+	allKinds = exportCmd.Flags().Bool("all-kinds", false, "export every namespaced kind that can be listed, except ephemeral ones like events, endpoints or leases")
+	clusterScoped = exportCmd.Flags().Bool("cluster-scoped", false, "export every cluster scoped kind that can be listed, except ephemeral ones like nodes")
+	includeKinds = exportCmd.Flags().StringArray("include-kinds", nil, "comma separated kinds to include in --all-kinds, --cluster-scoped and migrate --all, even though they're skipped by default")
+	excludeKinds = exportCmd.Flags().StringArray("exclude-kinds", nil, "comma separated kinds to leave out of --all-kinds, --cluster-scoped and migrate --all")
+	migrateCmd.Flags().AddFlag(exportCmd.Flags().Lookup("include-kinds"))
+	migrateCmd.Flags().AddFlag(exportCmd.Flags().Lookup("exclude-kinds"))
+	exportConcurrency = exportCmd.Flags().Int("concurrency", 4, "number of list calls and neat workers running at the same time")
 	migrateCmd.Flags().String("source-context", "", "source cluster context name")
 	migrateCmd.Flags().String("target-context", "", "target cluster context name")
+	migrateAll = migrateCmd.Flags().Bool("all", false, "migrate every kind that can be listed in the namespaces, except ephemeral ones like events, endpoints or leases")
+	migrateAllNamespaces = migrateCmd.Flags().BoolP("all-namespaces", "A", false, "migrate from all namespaces")
+	migrateDryRun = migrateCmd.Flags().String("dry-run", "none", "only print the objects that would be migrated: none, client, or server to have the target cluster validate them without persisting")
+	migrateCmd.Flags().Lookup("dry-run").NoOptDefVal = "client"
+	migrateDiff = migrateCmd.Flags().Bool("diff", false, "print a unified diff of each object against the target cluster instead of migrating, and fail if any differs. can't be combined with --dry-run")
+	migrateWaitTimeout = migrateCmd.Flags().Duration("wait-timeout", time.Minute, "how long to wait for migrated CRDs to be established before applying their custom resources")
+	// migrate doesn't take them, the target cluster needs the real values
+	for _, c := range []*cobra.Command{rootCmd, getCmd, exportCmd} {
+		c.Flags().StringVar(&secretsMode, "secrets", string(secrets.Keep), "what to do with the values of Secrets: keep, redact (keep the keys only), omit (drop Secrets) or encrypt (to --age-recipient)")
+		c.Flags().StringArrayVar(&ageRecipients, "age-recipient", nil, "age public key (age1...) --secrets=encrypt encrypts to, can be repeated")
+	}
+	for _, c := range []*cobra.Command{exportCmd, migrateCmd} {
+		c.Flags().StringArrayVar(&namespaceMapPairs, "namespace-map", nil, "move objects from a namespace to another as src=dst, can be repeated")
+		c.Flags().StringVar(&namespaceMapFile, "namespace-map-file", "", "file with a src=dst namespace mapping per line")
+		c.MarkFlagFilename("namespace-map-file")
+		c.Flags().StringArrayVar(&imageRewriteRules, "image-rewrite", nil, "rewrite container images as prefix=replacement, e.g. docker.io/=registry.local/dockerhub/, or regex:expr=replacement, can be repeated")
+		c.Flags().StringVar(&imageRewriteFile, "image-rewrite-file", "", "file with an --image-rewrite rule per line")
+		c.MarkFlagFilename("image-rewrite-file")
+		c.Flags().StringArrayVar(&storageClassPairs, "storage-class-map", nil, "move volumes and claims from a storage class to another as old=new, can be repeated. claims drop their volumeName to provision new volumes")
+		c.Flags().BoolVar(&preservePVBinding, "preserve-pv-binding", false, "keep claims bound to their volumes: claims keep their volumeName and volumes get a claimRef to them")
+		c.Flags().StringVarP(&labelSelector, "selector", "l", "", "label selector to filter the listed objects on, e.g. app=payments")
+		c.Flags().StringVar(&fieldSelector, "field-selector", "", "field selector to filter the listed objects on, e.g. metadata.name!=default")
+		c.Flags().BoolVar(&includeOwned, "include-owned", false, "include objects owned by a controller that is exported too, e.g. the pods of a ReplicaSet")
+	}
+	migrateCmd.Flags().IntVar(exportConcurrency, "concurrency", 4, "number of list calls and neat workers running at the same time")
 	migrateCmd.MarkFlagRequired("source-context")
 	migrateCmd.MarkFlagRequired("target-context")
+	kubeConfigFlags.AddFlags(rootCmd.PersistentFlags())
 	rootCmd.SetOut(os.Stdout)
 	rootCmd.SetErr(os.Stderr)
 	rootCmd.MarkFlagFilename("file")
+	rootCmd.MarkPersistentFlagFilename("rules", "yaml", "yml", "json")
+	rootCmd.MarkPersistentFlagFilename("crd-file", "yaml", "yml", "json")
 	rootCmd.AddCommand(getCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(exportCmd)
@@ -56,50 +183,182 @@ var rootCmd = &cobra.Command{
 	Example: `kubectl get pod mypod -o yaml | kubectl neatx
 kubectl neatx -f - <./my-pod.json
 kubectl neatx -f ./my-pod.json
-kubectl neatx -f ./my-pod.json --output yaml`,
+kubectl neatx -f ./my-pod.json --output yaml
+kubectl neatx -f ./manifests -R -f 'extra/*.json'
+kubectl neatx -f ./manifests -R --in-place --backup-suffix .orig
+helm template my-release ./chart | kubectl neatx
+kubectl neatx -f ./my-pod.json --rules ./my-rules.yaml
+kubectl neatx -f ./my-deploy.yaml --strip-defaults
+kubectl neatx -f ./my-certificate.yaml --strip-defaults --crd-file ./cert-manager.crds.yaml
+kubectl neatx -f ./my-pvc.yaml --keep-finalizers --keep-metadata Job:generateName
+kubectl neatx -f ./my-secret.yaml --secrets encrypt --age-recipient age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadRules(*rulesFiles, *noBuiltinRules); err != nil {
+			return err
+		}
+		if err := loadMetadataPolicy(); err != nil {
+			return err
+		}
+		if err := loadSecretPolicy(); err != nil {
+			return err
+		}
+		return loadCRDs(cmdContext(cmd), *crdFiles, *crdsFromCluster)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		var in, out []byte
-		var err error
-		if *inputFile == "-" {
-			stdin := cmd.InOrStdin()
-			in, err = io.ReadAll(stdin)
-			if err != nil {
-				return err
-			}
-		} else {
-			in, err = os.ReadFile(*inputFile)
-			if err != nil {
-				return err
-			}
+		if *backupSuffix != "" && !*inPlace {
+			return fmt.Errorf("--backup-suffix needs --in-place")
+		}
+		if *inPlace && cmd.Flag("output").Changed {
+			return fmt.Errorf("--in-place keeps the format of each file, it can't be used with --output")
+		}
+		paths, err := inputPaths(*inputFiles, *recursive)
+		if err != nil {
+			return err
+		}
+		if *inPlace {
+			return neatInPlace(cmd, paths, *backupSuffix)
 		}
 		outFormat := *outputFormat
 		if !cmd.Flag("output").Changed {
 			outFormat = "same"
 		}
-		out, err = NeatYAMLOrJSON(in, outFormat)
+		return neatFiles(cmd, paths, outFormat)
+	},
+}
+
+// loadNamespaceMap sets the namespace map from the --namespace-map pairs and file, the pairs win over the file
+func loadNamespaceMap(pairs []string, file string) error {
+	m := remap.NamespaceMap{}
+	if file != "" {
+		fileMap, err := remap.LoadNamespaceMapFile(file)
+		if err != nil {
+			return err
+		}
+		m = fileMap
+	}
+	flagMap, err := remap.ParseNamespaceMap(pairs)
+	if err != nil {
+		return err
+	}
+	namespaceMap = m.Merge(flagMap)
+	return nil
+}
+
+// loadImageMap sets the image map from the --image-rewrite rules and file, the rules come first
+func loadImageMap(specs []string, file string) error {
+	m, err := remap.ParseImageMap(specs)
+	if err != nil {
+		return err
+	}
+	if file != "" {
+		fileMap, err := remap.LoadImageMapFile(file)
 		if err != nil {
 			return err
 		}
-		cmd.Print(string(out))
-		return nil
-	},
+		m = append(m, fileMap...)
+	}
+	imageMap = m
+	return nil
+}
+
+// loadStorageMap sets the storage map from the --storage-class-map pairs and --preserve-pv-binding
+func loadStorageMap(pairs []string, preserveBinding bool) error {
+	classes, err := remap.ParseStorageClassMap(pairs)
+	if err != nil {
+		return err
+	}
+	storageMap = remap.StorageMap{Classes: classes, PreserveBinding: preserveBinding}
+	return nil
+}
+
+// loadMetadataPolicy sets the metadata policy from the flags. the --keep-* flags come first, so
+// --keep-metadata and --drop-metadata can refine them per kind
+func loadMetadataPolicy() error {
+	p := metadata.DefaultPolicy()
+	p.FlagGenerated = *flagGenerated
+	if *keepOwnerRefs {
+		p.Set("", "ownerReferences", true)
+	}
+	if *keepFinalizers {
+		p.Set("", "finalizers", true)
+	}
+	if *keepGenerateName {
+		p.Set("", "generateName", true)
+	}
+	for _, spec := range *keepMetadata {
+		if err := p.SetSpec(spec, true); err != nil {
+			return err
+		}
+	}
+	for _, spec := range *dropMetadata {
+		if err := p.SetSpec(spec, false); err != nil {
+			return err
+		}
+	}
+	metadataPolicy = p
+	return nil
 }
 
-var kubectl string = "kubectl"
+// loadSecretPolicy sets the secret policy from the --secrets and --age-recipient flags
+func loadSecretPolicy() error {
+	p, err := secrets.NewPolicy(secretsMode, ageRecipients)
+	if err != nil {
+		return err
+	}
+	secretPolicy = p
+	return nil
+}
+
+// loadRules sets the active rule set from the built-in rules and the given rules files
+func loadRules(files []string, noBuiltin bool) error {
+	rs := rules.Builtin()
+	if noBuiltin {
+		rs = &rules.RuleSet{}
+	}
+	for _, f := range files {
+		fileRules, err := rules.LoadFile(f)
+		if err != nil {
+			return err
+		}
+		rs = rs.Merge(fileRules)
+	}
+	activeRules = rs
+	return nil
+}
 
 var getCmd = &cobra.Command{
 	Use:   "get",
 	Short: "Print specific resource manifest",
 	Example: `kubectl neatx get -- pod mypod -oyaml
-kubectl neatx get -- svc -n default myservice --output json`,
+kubectl neatx get -- svc -n default myservice --output json
+kubectl neatx get -- pods -n app1,app2 -l app=web --field-selector status.phase=Running`,
 	FParseErrWhitelist: cobra.FParseErrWhitelist{UnknownFlags: true}, //don't try to validate kubectl get's flags
 	RunE: func(cmd *cobra.Command, args []string) error {
-		outputFormat := outFmt(cmd, args)
-		out, err := get(args, outputFormat)
+		ga, err := parseGetArgs(args)
+		if err != nil {
+			return err
+		}
+		c, err := newClients(kubeConfigFlags)
 		if err != nil {
 			return err
 		}
-		cmd.Println(out)
+		kres, err := get(cmdContext(cmd), c, ga)
+		if err != nil {
+			return err
+		}
+		outFormat := *outputFormat
+		if ga.output != "" && !cmd.Flag("output").Changed {
+			outFormat = ga.output
+		}
+		out, err := NeatYAMLOrJSON(kres, outFormat)
+		if err != nil {
+			return err
+		}
+		if len(out) == 0 {
+			// the object is omitted, e.g. a Secret with --secrets=omit
+			return nil
+		}
+		cmd.Println(string(out))
 		return nil
 	},
 }
@@ -140,6 +399,10 @@ func NeatYAMLOrJSON(in []byte, outputFormat string) (out []byte, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("error neating : %v", err)
 	}
+	if outjson == "" {
+		// omitted, e.g. a Secret with --secrets=omit
+		return nil, nil
+	}
 
 	if outputFormat == "yaml" || (outputFormat == "same" && itsYaml) {
 		out, err = yaml.JSONToYAML([]byte(outjson))
@@ -152,263 +415,253 @@ func NeatYAMLOrJSON(in []byte, outputFormat string) (out []byte, err error) {
 	return
 }
 
-func get(args []string, outFormat string) (string, error) {
-	var out []byte
-	var err error
-	//reset defaults
-	//there are two output settings in this subcommand: kubectl get's and kubectl-neat's
-	//any combination of those can be provided by using the output flag in either side of the --
-	//the most efficient is kubectl: json, kubectl-neat: yaml
-	//0--0->Y--J #choose what's best for us
-	//0--Y->Y--Y #user did specify output in kubectl, so respect that
-	//0--J->J--J #user did specify output in kubectl, so respect that
-	//Y--0->Y--J #user doesn't care about kubectl so use json but convert back
-	//J--0->J--J #user expects json so use it for foth
-	//if the user specified both side we can't touch it
-
-	//the desired kubectl get output is always json, unless it was explicitly set by the user to yaml in which case the arg is overriden when concatenating the args later
-	cmdArgs := append([]string{"get", "-o", "json"}, args...)
-	kubectlCmd := exec.Command(kubectl, cmdArgs...)
-	kres, err := kubectlCmd.CombinedOutput()
-	if err != nil {
-		// return "", fmt.Errorf("error invoking kubectl as %v %v", cmdArgs, err)
-		return "", err
-	}
-
-	out, err = NeatYAMLOrJSON(kres, outFormat)
-	if err != nil {
-		return "", err
-	}
-	// cmd.Println(string(out))
-	return string(out), nil
-}
-
-func getAllNamespaces() ([]string, error) {
-	var res []string
-	cmd := exec.Command(kubectl, "get", "namespace", "-o", "name")
-	cmdout, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, err
-	}
-	namespacesSLice := s.Split(string(cmdout), "\n")
-	for _, namespace := range namespacesSLice[:len(namespacesSLice)-1] {
-		res = append(res, s.Split(namespace, "/")[1])
+// neatStream neats a stream of yaml documents or json values one at a time, so memory doesn't grow with the input
+func neatStream(in io.Reader, w *documentWriter, outputFormat string) error {
+	reader, _, itsJSON := utilyaml.GuessJSONStream(in, 4096)
+	if outputFormat == "same" {
+		outputFormat = "yaml"
+		if itsJSON {
+			outputFormat = "json"
+		}
 	}
-	return res, nil
-}
-
-func getapiResource() []string {
-	//获取api-resources
-	var apiResources []string
-
-	content, _ := folder.ReadFile("api-resources.txt")
-
-	apiResourcesCmd := exec.Command(kubectl, "api-resources", "--no-headers")
-	apiResourcesCmdOut, err := apiResourcesCmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("error invoking kubectl as %v %v, getting embedding api-resources..", apiResourcesCmd.Args, err)
-		apiResources = s.Split(string(content), "\n")[:len(s.Split(string(content), "\n"))-1]
-		// return fmt.Errorf("error invoking kubectl as %v %v", apiResourcesCmd.Args, err)
+	var next func() ([]byte, error)
+	if itsJSON {
+		decoder := json.NewDecoder(reader)
+		next = func() ([]byte, error) {
+			var doc json.RawMessage
+			err := decoder.Decode(&doc)
+			return doc, err
+		}
 	} else {
-		apiResources = s.Split(string(apiResourcesCmdOut), "\n")[:len(s.Split(string(apiResourcesCmdOut), "\n"))-1]
+		yamlReader := utilyaml.NewYAMLReader(bufio.NewReader(reader))
+		next = yamlReader.Read
 	}
-	return apiResources
-}
-
-var migrateCmd = &cobra.Command{
-	Use:   "migrate",
-	Short: "Migrate resources between clusters",
-	Example: `kubectl neatx migrate --source-context=ctx1 --target-context=ctx2 deploy/myapp -n default
-kubectl neatx migrate --source-context=ctx1 --target-context=ctx2 --all -n default`,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		sourceContext := cmd.Flag("source-context").Value.String()
-		targetContext := cmd.Flag("target-context").Value.String()
 
-		// Get resources from source cluster
-		kubectlCmd := exec.Command(kubectl, "--context", sourceContext, "get", "-o", "json", args[0])
-		kres, err := kubectlCmd.CombinedOutput()
+	for n := 1; ; n++ {
+		doc, err := next()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			return err
+			return fmt.Errorf("error reading document %d : %v", n, err)
 		}
-
-		// Neat the resource
-		out, err := NeatYAMLOrJSON(kres, "json")
+		if emptyDocument(doc) {
+			continue
+		}
+		neated, err := NeatYAMLOrJSON(doc, outputFormat)
 		if err != nil {
+			return fmt.Errorf("error in document %d : %v", n, err)
+		}
+		if neated == nil {
+			continue
+		}
+		if err := w.write(neated, outputFormat == "yaml"); err != nil {
 			return err
 		}
+	}
+}
 
-		// Apply to target cluster
-		applyCmd := exec.Command(kubectl, "--context", targetContext, "apply", "-f", "-")
-		applyCmd.Stdin = bytes.NewReader(out)
-		applyOut, err := applyCmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("%s: %v", string(applyOut), err)
+// emptyDocument tells if a yaml document holds nothing but blank lines and comments
+func emptyDocument(doc []byte) bool {
+	for _, line := range bytes.Split(doc, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] != '#' {
+			return false
 		}
+	}
+	return true
+}
 
-		cmd.Println(string(applyOut))
-		return nil
-	},
+// getArgs are the arguments of the get subcommand, given the way kubectl get takes them
+type getArgs struct {
+	// targets are the resources to get, a target without name lists all the objects of its resource
+	targets []getTarget
+	// namespaces are the comma separated namespaces of -n
+	namespaces    []string
+	allNamespaces bool
+	output        string
+	labelSelector string
+	fieldSelector string
 }
 
-var exportCmd = &cobra.Command{
-	Use:     "export",
-	Short:   "Batch export of specified resource manifests",
-	Example: `kubectl neatx export -n default deploy,sts,svc ...`,
-	// FParseErrWhitelist: cobra.FParseErrWhitelist{UnknownFlags: true}, //don't try to validate kubectl get's flags
-	RunE: func(cmd *cobra.Command, args []string) error {
-		var namespacesList []string
-		var err error
-
-		//获取kind清单
-		var kindList []string
-		for _, arg := range args {
-			kindList = append(kindList, s.Split(arg, ",")...)
-		}
-
-		outputFormat := outFmt(cmd, args)
-
-		//存储目录初始化
-		var outDir string
-		var clustrdDir = "Cluster"
-		outDir = *exportOutDir
-		// err = os.MkdirAll(outDir, 0755)
-		// if err != nil {
-		// 	return err
-		// }
-		// err = os.MkdirAll(path.Join(outDir, clustrdDir), 0755)
-		// if err != nil {
-		// 	return err
-		// }
-
-		//获取命名空间slice
-		if *allNamespaces {
-			namespacesList, err = getAllNamespaces()
+// ignoredGetFlags are kubectl get flags that only change the table output, and whether they take a value
+var ignoredGetFlags = map[string]bool{
+	"--show-labels":         false,
+	"--show-kind":           false,
+	"--no-headers":          false,
+	"--show-managed-fields": false,
+	"-L":                    true,
+	"--label-columns":       true,
+}
+
+type getTarget struct {
+	resource string
+	name     string
+}
+
+// parseGetArgs parses the arguments passed through to get: TYPE [NAME...], TYPE1,TYPE2, TYPE/NAME... and the
+// -n/--namespace, -A/--all-namespaces, -o/--output, -l/--selector and --field-selector flags
+func parseGetArgs(args []string) (*getArgs, error) {
+	res := &getArgs{}
+	var positionals []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func(long string, short string) (string, bool, error) {
+			switch {
+			case arg == long || arg == short:
+				if i+1 >= len(args) {
+					return "", true, fmt.Errorf("flag needs an argument: %s", arg)
+				}
+				i++
+				return args[i], true, nil
+			case s.HasPrefix(arg, long+"="):
+				return s.TrimPrefix(arg, long+"="), true, nil
+			case s.HasPrefix(arg, short) && !s.HasPrefix(arg, "--"):
+				return s.TrimPrefix(s.TrimPrefix(arg, short), "="), true, nil
+			}
+			return "", false, nil
+		}
+		if v, ok, err := value("--namespace", "-n"); ok {
 			if err != nil {
-				return err
+				return nil, err
 			}
-		} else {
-			namespacesList = append(namespacesList, s.Split(*namespace, ",")...)
-		}
-
-		//执行
-		apiResources := getapiResource()
-		var namespacedKindList []string
-		for _, kind := range kindList {
-			var kindDir string
-			//判断是否为Cluster的kind
-			condition := isClusterKind(kind, apiResources)
-			if condition {
-				kindDir = path.Join(outDir, clustrdDir, kind)
-				err := os.MkdirAll(kindDir, 0755)
-				if err != nil {
-					return err
-				}
-				getManifest(kindDir, kind, "default", outputFormat)
-
-			} else {
-				namespacedKindList = append(namespacedKindList, kind)
+			res.namespaces = s.Split(v, ",")
+			continue
+		}
+		if v, ok, err := value("--output", "-o"); ok {
+			if err != nil {
+				return nil, err
 			}
+			res.output = v
+			continue
 		}
-
-		for _, ns := range namespacesList {
-			nsDir := fmt.Sprintf("%s/%s", outDir, ns)
-			err := os.MkdirAll(nsDir, 0755)
+		if v, ok, err := value("--selector", "-l"); ok {
 			if err != nil {
-				return err
+				return nil, err
 			}
-
-			for _, kind := range namespacedKindList {
-				kindDir := path.Join(nsDir, kind)
-				err := os.MkdirAll(kindDir, 0755)
-				if err != nil {
-					return err
-				}
-
-				getManifest(kindDir, kind, ns, outputFormat)
-
+			res.labelSelector = v
+			continue
+		}
+		if v, ok, err := value("--field-selector", "--field-selector"); ok {
+			if err != nil {
+				return nil, err
 			}
+			res.fieldSelector = v
+			continue
 		}
-		return nil
-	},
-}
+		name, _, hasValue := s.Cut(arg, "=")
+		if takesValue, ok := ignoredGetFlags[name]; ok {
+			log.Warnf("ignoring %s, it doesn't change the yaml or json output", name)
+			if takesValue && !hasValue {
+				i++
+			}
+			continue
+		}
+		switch {
+		case arg == "-A" || arg == "--all-namespaces":
+			res.allNamespaces = true
+		case s.HasPrefix(arg, "-") && arg != "-":
+			return nil, fmt.Errorf("unknown flag: %s", arg)
+		default:
+			positionals = append(positionals, arg)
+		}
+	}
+	if res.output != "" && res.output != "yaml" && res.output != "json" {
+		return nil, fmt.Errorf("unsupported output format '%s', use yaml or json", res.output)
+	}
 
-func isClusterKind(name string, cache []string) bool {
-	if cache == nil {
-		return false
-	}
-
-	for _, api := range cache {
-		api = deleteExtraSpace(api)
-		apiSlice := s.Split(api, " ")
-		len := len(apiSlice)
-		if len == 4 {
-			if s.ToLower(apiSlice[3]) == name {
-				if apiSlice[2] == "true" {
-					return false
-				} else {
-					return true
-				}
+	if len(positionals) == 0 || positionals[0] == "" {
+		return nil, fmt.Errorf("you must specify the type of resource to get")
+	}
+	if s.Contains(positionals[0], "/") {
+		for _, p := range positionals {
+			parts := s.SplitN(p, "/", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("arguments in resource/name form must have a single resource and name, got '%s'", p)
 			}
-			if apiSlice[0] == name {
-				if apiSlice[2] == "true" {
-					return false
-				} else {
-					return true
-				}
+			res.targets = append(res.targets, getTarget{resource: parts[0], name: parts[1]})
+		}
+		return res, nil
+	}
+	for _, resource := range s.Split(positionals[0], ",") {
+		if len(positionals) == 1 {
+			res.targets = append(res.targets, getTarget{resource: resource})
+		}
+		for _, name := range positionals[1:] {
+			if s.Contains(name, "/") {
+				return nil, fmt.Errorf("there is no need to specify a resource type as a separate argument when passing arguments in resource/name form")
 			}
+			res.targets = append(res.targets, getTarget{resource: resource, name: name})
 		}
+	}
+	return res, nil
+}
 
-		if len == 5 {
-			if apiSlice[1] == name {
-				if apiSlice[3] == "true" {
-					return false
-				} else {
-					return true
+// get fetches the targets of 'ga' as json: the object itself when a single named object is asked for, a List otherwise
+func get(ctx context.Context, c *clients, ga *getArgs) ([]byte, error) {
+	namespaces := ga.namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{c.namespace}
+	}
+	if ga.allNamespaces {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	listOptions := metav1.ListOptions{LabelSelector: ga.labelSelector, FieldSelector: ga.fieldSelector}
+	var items []unstructured.Unstructured
+	for _, t := range ga.targets {
+		r, err := c.resourceFor(t.resource)
+		if err != nil {
+			return nil, err
+		}
+		targetNamespaces := namespaces
+		if !r.Namespaced {
+			targetNamespaces = []string{metav1.NamespaceAll}
+		}
+		for _, ns := range targetNamespaces {
+			if t.name == "" {
+				list, err := c.listItems(ctx, r, ns, listOptions)
+				if err != nil {
+					return nil, err
 				}
+				items = append(items, list...)
+				continue
 			}
-			if s.ToLower(apiSlice[4]) == name {
-				if apiSlice[3] == "true" {
-					return false
-				} else {
-					return true
-				}
+			obj, err := c.getJSON(ctx, r, ns, t.name)
+			if err != nil {
+				return nil, err
 			}
-			if apiSlice[0] == name {
-				if apiSlice[3] == "true" {
-					return false
-				} else {
-					return true
-				}
+			if len(ga.targets) == 1 && len(targetNamespaces) == 1 {
+				return obj, nil
 			}
+			var item unstructured.Unstructured
+			if err := item.UnmarshalJSON(obj); err != nil {
+				return nil, err
+			}
+			items = append(items, item)
 		}
-
 	}
-
-	return false
+	return listJSON(items)
 }
 
-func getManifest(kindDir string, kind string, ns string, outFmt string) {
-	//获取资源名字列表
-	kubectlCmd := exec.Command(kubectl, "get", kind, "-n", ns, "-o", "name")
-	kcmdRes, err := kubectlCmd.CombinedOutput()
-	if err != nil {
-		// return fmt.Errorf("error invoking kubectl as %v %v", kubectlCmd.Args, err)
-		fmt.Printf("%s", string(kcmdRes))
-		os.Remove(kindDir)
-	} else {
-		resoucesSLice := s.Split(string(kcmdRes), "\n")
-		for _, name := range resoucesSLice[:len(resoucesSLice)-1] {
-			out, err := get([]string{name, "-n", ns}, outFmt)
-			if err != nil {
-				fmt.Printf("%v", err)
-			} else {
-				resourceFile := fmt.Sprintf("%s/%s.%s", kindDir, s.Split(name, "/")[1], outFmt)
-				fmt.Println(resourceFile)
-				os.WriteFile(resourceFile, []byte(out), 0644)
-			}
-
+// loadCRDs registers the schemas of CustomResourceDefinitions from files and from the cluster, for default stripping
+func loadCRDs(ctx context.Context, files []string, fromCluster bool) error {
+	for _, f := range files {
+		if err := defaults.LoadCRDFile(f); err != nil {
+			return err
 		}
 	}
+	if !fromCluster {
+		return nil
+	}
+	c, err := newClients(kubeConfigFlags)
+	if err != nil {
+		return err
+	}
+	crds, err := get(ctx, c, &getArgs{targets: []getTarget{{resource: "customresourcedefinitions.apiextensions.k8s.io"}}})
+	if err != nil {
+		return fmt.Errorf("error fetching CRDs from the cluster : %v", err)
+	}
+	return defaults.LoadCRDs(bytes.NewReader(crds))
 }
 
 func outFmt(cmd *cobra.Command, args []string) string {
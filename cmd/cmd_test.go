@@ -2,15 +2,38 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/resources"
+	"github.com/Baiyuani/kubectl-neatx/pkg/secrets"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 )
 
 func assertErrorNil(err error) bool {
 	return err == nil
 }
+
+// resetFileFlag gives -f back its default, repeated -f flags would add up across parses otherwise
+func resetFileFlag() {
+	fresh := &cobra.Command{}
+	fresh.Flags().StringArrayVarP(inputFiles, "file", "f", []string{"-"}, "")
+	f := rootCmd.Flags().Lookup("file")
+	f.Value, f.Changed = fresh.Flags().Lookup("file").Value, false
+}
+
 func TestRootCmd(t *testing.T) {
 	resourceDataJSONPath := "../test/fixtures/service1-raw.json"
 	resourceDataJSONBytes, err := os.ReadFile(resourceDataJSONPath)
@@ -79,6 +102,7 @@ func TestRootCmd(t *testing.T) {
 	}
 
 	for _, tc := range testcases {
+		resetFileFlag()
 		rootCmd.SetArgs(tc.args)
 		if tc.stdin != "" {
 			rootCmd.SetIn(bytes.NewReader([]byte(tc.stdin)))
@@ -109,8 +133,181 @@ func TestRootCmd(t *testing.T) {
 	}
 }
 
+func TestRootCmdFiles(t *testing.T) {
+	dir := t.TempDir()
+	yamlCM := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n  uid: c0ffee\n"
+	jsonCM := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "b", "uid": "c0ffee"}}`
+	files := map[string]string{
+		"a.yaml":     yamlCM,
+		"sub/b.json": jsonCM,
+		"sub/c.yml":  strings.ReplaceAll(yamlCM, "name: a", "name: c"),
+		"notes.txt":  "not a manifest",
+	}
+	write := func() {
+		for f, content := range files {
+			os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0755)
+			os.WriteFile(filepath.Join(dir, f), []byte(content), 0644)
+		}
+	}
+	write()
+	run := func(args ...string) (string, error) {
+		resetFileFlag()
+		defer func() { *recursive, *inPlace, *backupSuffix = false, false, "" }()
+		cmdout := new(bytes.Buffer)
+		rootCmd.SetOut(cmdout)
+		rootCmd.SetErr(cmdout)
+		defer rootCmd.SetOut(os.Stdout)
+		defer rootCmd.SetErr(os.Stderr)
+		rootCmd.ParseFlags(args)
+		err := rootCmd.RunE(rootCmd, nil)
+		return strings.ReplaceAll(cmdout.String(), dir, "<dir>"), err
+	}
+
+	neatA := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n"
+	neatB := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name":"b"}}`
+	neatC := strings.ReplaceAll(neatA, "name: a", "name: c")
+	cases := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"-f", dir}, neatA},
+		{[]string{"-f", dir, "-R"}, neatA + "---\n" + neatB + "\n---\n" + neatC},
+		{[]string{"-f", filepath.Join(dir, "sub", "*")}, neatB + "\n---\n" + neatC},
+		{[]string{"-f", filepath.Join(dir, "sub", "c.yml"), "-f", filepath.Join(dir, "a.yaml"), "-f", filepath.Join(dir, "*.yaml")}, neatC + "---\n" + neatA},
+	}
+	for _, c := range cases {
+		out, err := run(c.args...)
+		if err != nil {
+			t.Errorf("test case '%v' failed. error: %v", c.args, err)
+			continue
+		}
+		if out != c.expect {
+			t.Errorf("test case '%v' failed. want: '%s' have: '%s'", c.args, c.expect, out)
+		}
+	}
+
+	if _, err := run("-f", filepath.Join(dir, "*.xml")); err == nil {
+		t.Errorf("want error for a glob without matches")
+	}
+	if _, err := run("-f", dir, "--backup-suffix", ".orig"); err == nil {
+		t.Errorf("want error for --backup-suffix without --in-place")
+	}
+
+	out, err := run("-f", dir, "-R", "--in-place", "--backup-suffix", ".orig")
+	if err != nil {
+		t.Fatalf("error neating in place: %v", err)
+	}
+	for f, want := range map[string]string{"a.yaml": neatA, "sub/b.json": neatB, "sub/c.yml": neatC, "notes.txt": files["notes.txt"], "a.yaml.orig": yamlCM, "sub/b.json.orig": jsonCM} {
+		have, _ := os.ReadFile(filepath.Join(dir, f))
+		if string(have) != want {
+			t.Errorf("in place: file %s differs. want: '%s' have: '%s'", f, want, have)
+		}
+	}
+	wantOut := "<dir>/a.yaml\n<dir>/sub/b.json\n<dir>/sub/c.yml\n"
+	if out != wantOut {
+		t.Errorf("in place: want output: '%s' have: '%s'", wantOut, out)
+	}
+	// neat files are left alone
+	if out, err := run("-f", dir, "-R", "--in-place"); err != nil || out != "" {
+		t.Errorf("in place: want nothing rewritten, have: '%s' error: %v", out, err)
+	}
+
+	// a file whose objects are all omitted isn't emptied
+	secret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\n  uid: c0ffee\n"
+	os.WriteFile(filepath.Join(dir, "secret.yaml"), []byte(secret), 0644)
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	secretPolicy = &secrets.Policy{Mode: secrets.Omit}
+	defer func() { secretPolicy = nil }()
+	out, err = run("-f", filepath.Join(dir, "secret.yaml"), "-f", filepath.Join(dir, "a.yaml"), "--in-place")
+	if err != nil || out != "" {
+		t.Errorf("in place: want nothing rewritten, have: '%s' error: %v", out, err)
+	}
+	if have, _ := os.ReadFile(filepath.Join(dir, "secret.yaml")); string(have) != secret {
+		t.Errorf("in place: want the omitted secret left untouched, have: '%s'", have)
+	}
+	if !strings.Contains(logs.String(), "secret.yaml untouched") {
+		t.Errorf("in place: want a warning about the omitted secret, have: %s", logs.String())
+	}
+}
+
+func TestNeatStream(t *testing.T) {
+	cm := func(name string) string {
+		return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n  uid: c0ffee\ndata:\n  a: b\n"
+	}
+	neated := func(name string) string {
+		return "apiVersion: v1\ndata:\n  a: b\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n"
+	}
+	cases := []struct {
+		title  string
+		in     string
+		format string
+		expect string
+	}{
+		{"single document", cm("a"), "same", neated("a")},
+		{"documents", "---\n" + cm("a") + "---\n# comment only\n---\n" + cm("b") + "...\n", "same", neated("a") + "---\n" + neated("b")},
+		{"json values", `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "a", "uid": "1"}} {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "b"}}`, "same",
+			`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name":"a"}}` + "\n" + `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name":"b"}}`},
+		{"json values to yaml", `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "a"}, "data": {"a": "b"}}` + "\n" + `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "b"}, "data": {"a": "b"}}`, "yaml",
+			neated("a") + "---\n" + neated("b")},
+		{"empty", "", "same", ""},
+	}
+	for _, c := range cases {
+		out := new(bytes.Buffer)
+		if err := neatStream(strings.NewReader(c.in), &documentWriter{out: out}, c.format); err != nil {
+			t.Errorf("test case '%s' failed. error: %v", c.title, err)
+			continue
+		}
+		if out.String() != c.expect {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, out.String())
+		}
+	}
+
+	err := neatStream(strings.NewReader(cm("a")+"---\nkind: [\n"), &documentWriter{out: new(bytes.Buffer)}, "same")
+	if err == nil || !strings.Contains(err.Error(), "document 2") {
+		t.Errorf("want an error about document 2, have: %v", err)
+	}
+}
+
+// fakeCluster returns fake clients of a cluster holding 'objects', in namespace "default"
+func fakeCluster(objects ...runtime.Object) *clients {
+	resolver := resources.Builtin()
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, r := range resolver.Resources() {
+		listKinds[r.GroupVersionResource] = r.Kind + "List"
+	}
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+	return &clients{dynamic: dyn, resolver: resolver, namespace: "default"}
+}
+
+// fakeClients makes newClients return fake clients of a cluster holding 'objects'
+func fakeClients(objects ...runtime.Object) func() {
+	c := fakeCluster(objects...)
+	orig := newClients
+	newClients = func(flags *genericclioptions.ConfigFlags) (*clients, error) {
+		return c, nil
+	}
+	return func() { newClients = orig }
+}
+
+// fixtureObject reads an object from a json fixture
+func fixtureObject(t *testing.T, path string) *unstructured.Unstructured {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading test data file %s: %v", path, err)
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		t.Fatalf("error decoding test data file %s: %v", path, err)
+	}
+	return obj
+}
+
 func TestGetCmd(t *testing.T) {
-	kubectl = "../test/kubectl-stub"
+	pod := fixtureObject(t, "../test/fixtures/pod1-raw.json")
+	pod.SetName("mypod")
+	defer fakeClients(pod)()
 	testcases := []struct {
 		args        []string
 		assertError func(err error) bool
@@ -120,7 +317,7 @@ func TestGetCmd(t *testing.T) {
 		{
 			args: []string{""},
 			assertError: func(err error) bool {
-				return strings.HasPrefix(err.Error(), "error invoking kubectl")
+				return strings.HasPrefix(err.Error(), "you must specify the type of resource")
 			},
 			expOut: "",
 			expErr: "",
@@ -178,3 +375,99 @@ func TestGetCmd(t *testing.T) {
 		}
 	}
 }
+
+func TestGetCmdOmitted(t *testing.T) {
+	defer fakeClients(object("v1", "Secret", "default", "creds"))()
+	defer func() { secretPolicy = nil }()
+	secretPolicy = &secrets.Policy{Mode: secrets.Omit}
+	cmdout := new(bytes.Buffer)
+	getCmd.SetOut(cmdout)
+	defer getCmd.SetOut(nil)
+	if err := getCmd.RunE(getCmd, []string{"secret", "creds"}); err != nil {
+		t.Fatalf("error getting the secret: %v", err)
+	}
+	if cmdout.Len() > 0 {
+		t.Errorf("want no output for an omitted object, have: '%s'", cmdout.String())
+	}
+}
+
+func TestGetNamespacesAndSelectors(t *testing.T) {
+	pod := func(ns, name, app string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Pod")
+		obj.SetNamespace(ns)
+		obj.SetName(name)
+		obj.SetLabels(map[string]string{"app": app})
+		return obj
+	}
+	defer fakeClients(pod("ns1", "a", "x"), pod("ns1", "b", "y"), pod("ns2", "a", "x"), pod("ns3", "a", "x"))()
+	c, err := newClients(kubeConfigFlags)
+	if err != nil {
+		t.Fatalf("error creating clients: %v", err)
+	}
+	cases := []struct {
+		args   []string
+		expect []string
+	}{
+		{[]string{"pods", "-n", "ns1,ns2", "-l", "app=x"}, []string{"ns1/a", "ns2/a"}},
+		{[]string{"pods", "a", "-n", "ns2,ns3"}, []string{"ns2/a", "ns3/a"}},
+		{[]string{"pods", "-A", "--selector", "app!=x"}, []string{"ns1/b"}},
+	}
+	for _, tc := range cases {
+		ga, err := parseGetArgs(tc.args)
+		if err != nil {
+			t.Fatalf("error parsing %v: %v", tc.args, err)
+		}
+		out, err := get(context.Background(), c, ga)
+		if err != nil {
+			t.Errorf("test case '%v' failed. error: %v", tc.args, err)
+			continue
+		}
+		var have []string
+		gjson.GetBytes(out, "items").ForEach(func(_, item gjson.Result) bool {
+			have = append(have, item.Get("metadata.namespace").String()+"/"+item.Get("metadata.name").String())
+			return true
+		})
+		if !reflect.DeepEqual(have, tc.expect) {
+			t.Errorf("test case '%v' failed. want: '%v' have: '%v'", tc.args, tc.expect, have)
+		}
+	}
+}
+
+func TestParseGetArgs(t *testing.T) {
+	cases := []struct {
+		args []string
+		want getArgs
+		err  bool
+	}{
+		{args: []string{"pods", "mypod", "-n", "ns1", "-ojson"}, want: getArgs{targets: []getTarget{{"pods", "mypod"}}, namespaces: []string{"ns1"}, output: "json"}},
+		{args: []string{"deploy,svc", "--namespace=ns1,ns2"}, want: getArgs{targets: []getTarget{{"deploy", ""}, {"svc", ""}}, namespaces: []string{"ns1", "ns2"}}},
+		{args: []string{"pods", "-l", "app=x", "--field-selector=status.phase=Running"}, want: getArgs{targets: []getTarget{{"pods", ""}}, labelSelector: "app=x", fieldSelector: "status.phase=Running"}},
+		{args: []string{"pods", "--selector=app=x", "--show-labels", "-L", "tier", "--no-headers"}, want: getArgs{targets: []getTarget{{"pods", ""}}, labelSelector: "app=x"}},
+		{args: []string{"pod/a", "svc/b", "-A"}, want: getArgs{targets: []getTarget{{"pod", "a"}, {"svc", "b"}}, allNamespaces: true}},
+		{args: []string{"pods", "a", "b", "--output", "yaml"}, want: getArgs{targets: []getTarget{{"pods", "a"}, {"pods", "b"}}, output: "yaml"}},
+		{args: []string{"pods", "-n"}, err: true},
+		{args: []string{"pods", "-l"}, err: true},
+		{args: []string{"pods", "--foo"}, err: true},
+		{args: []string{"pods", "-o", "wide"}, err: true},
+		{args: []string{"pod/a", "b"}, err: true},
+		{args: []string{}, err: true},
+	}
+	for _, c := range cases {
+		have, err := parseGetArgs(c.args)
+		if c.err {
+			if err == nil {
+				t.Errorf("test case '%v' failed. want an error", c.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test case '%v' failed. error: %v", c.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(*have, c.want) {
+			t.Errorf("test case '%v' failed. want: '%+v' have: '%+v'", c.args, c.want, *have)
+		}
+	}
+}
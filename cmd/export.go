@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	s "strings"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/helm"
+	"github.com/Baiyuani/kubectl-neatx/pkg/kustomize"
+	"github.com/Baiyuani/kubectl-neatx/pkg/layout"
+	"github.com/Baiyuani/kubectl-neatx/pkg/order"
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Batch export of specified resource manifests",
+	Example: `kubectl neatx export -n default deploy,sts,svc ...
+kubectl neatx export --context prod -n app1,app2 deploy,cm -d ./prod
+kubectl neatx export -A cm,secret --concurrency 16
+kubectl neatx export -n app1 --all-kinds --exclude-kinds secrets
+kubectl neatx export -n app1 --all-kinds -f - | kubectl apply --context dev -f -
+kubectl neatx export -n app1 --all-kinds --kustomize --kustomize-generators -d ./base
+kubectl neatx export -n app1 deploy,svc,cm --helm-chart app1 -d ./charts
+kubectl neatx export --cluster-scoped --include-kinds nodes
+kubectl neatx export -n team-a-staging deploy,svc,rolebindings --namespace-map team-a-staging=team-a-prod`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var namespacesList []string
+		var err error
+		ctx := cmdContext(cmd)
+
+		if *exportSingleFile != "" && (cmd.Flags().Changed("layout") || cmd.Flags().Changed("dest-dir")) {
+			return fmt.Errorf("--single-file can't be given with --layout or --dest-dir")
+		}
+		if *exportKustomize && (*exportSingleFile != "" || *exportLayout != layout.Default) {
+			return fmt.Errorf("--kustomize needs the %s layout", layout.Default)
+		}
+		if *exportHelmChart != "" && (*exportSingleFile != "" || *exportKustomize || *exportLayout != layout.Default) {
+			return fmt.Errorf("--helm-chart can't be given with --single-file, --kustomize or --layout")
+		}
+		if (*allKinds || *clusterScoped) && len(args) > 0 {
+			return fmt.Errorf("kinds can't be given with --all-kinds or --cluster-scoped")
+		}
+		if err := loadNamespaceMap(namespaceMapPairs, namespaceMapFile); err != nil {
+			return err
+		}
+		if err := loadImageMap(imageRewriteRules, imageRewriteFile); err != nil {
+			return err
+		}
+		if err := loadStorageMap(storageClassPairs, preservePVBinding); err != nil {
+			return err
+		}
+		c, err := newClients(kubeConfigFlags)
+		if err != nil {
+			return err
+		}
+
+		//获取kind清单
+		var kindList []string
+		for _, arg := range args {
+			kindList = append(kindList, s.Split(arg, ",")...)
+		}
+
+		outputFormat := outFmt(cmd, args)
+
+		//存储目录初始化
+		outDir := *exportOutDir
+		fileLayout, err := layout.Parse(*exportLayout)
+		if err != nil {
+			return err
+		}
+
+		//获取命名空间slice
+		if *allNamespaces {
+			namespacesList, err = c.getAllNamespaces(ctx)
+			if err != nil {
+				return err
+			}
+		} else {
+			namespacesList = c.namespaces()
+		}
+
+		//执行
+		var targets []getTarget
+		for _, kind := range kindList {
+			targets = append(targets, getTarget{resource: kind})
+		}
+		for _, namespaced := range []bool{false, true} {
+			if (namespaced && *allKinds) || (!namespaced && *clusterScoped) {
+				discovered, err := exportableTargets(c, namespaced, splitKinds(*includeKinds), splitKinds(*excludeKinds))
+				if err != nil {
+					return err
+				}
+				targets = append(targets, discovered...)
+			}
+		}
+		jobs, err := exportJobs(c, targets, namespacesList, selectors())
+		if err != nil {
+			return err
+		}
+
+		results := runExport(ctx, c, jobs, *exportConcurrency)
+
+		// files are written once everything is listed and neated, in the order of the results.
+		// objects the layout puts in the same file are written together
+		var paths []string
+		docs := map[string][][]byte{}
+		failed, skipped := 0, 0
+		for _, res := range results {
+			if res.owner != "" {
+				skipped++
+				continue
+			}
+			if res.err != nil {
+				failed++
+				cmd.PrintErrln(res.errorString())
+				continue
+			}
+			for _, line := range res.report {
+				cmd.PrintErrln(line)
+			}
+			r := res.job.resource
+			p, err := fileLayout.Path(layout.Object{
+				Group:     r.Group,
+				Version:   r.Version,
+				Kind:      r.Kind,
+				Resource:  r.String(),
+				Namespace: res.namespace,
+				Name:      res.name,
+				Ext:       outputFormat,
+			})
+			if err != nil {
+				return err
+			}
+			if _, ok := docs[p]; !ok {
+				paths = append(paths, p)
+			}
+			docs[p] = append(docs[p], res.json)
+		}
+		if *exportSingleFile != "" {
+			var all [][]byte
+			for _, p := range paths {
+				all = append(all, docs[p]...)
+			}
+			out, err := joinDocuments(all, outputFormat, true)
+			if err != nil {
+				return err
+			}
+			if *exportSingleFile == "-" {
+				cmd.OutOrStdout().Write(out)
+			} else if err := writeExportFile(cmd, *exportSingleFile, out); err != nil {
+				return err
+			}
+			paths = nil
+		}
+		if *exportHelmChart != "" {
+			var all [][]byte
+			for _, p := range paths {
+				all = append(all, docs[p]...)
+			}
+			files, err := helm.Build(*exportHelmChart, sortDocuments(all))
+			if err != nil {
+				return fmt.Errorf("error building the chart : %v", err)
+			}
+			for _, f := range files {
+				if err := writeExportFile(cmd, path.Join(outDir, *exportHelmChart, f.Path), f.Data); err != nil {
+					return err
+				}
+			}
+			paths = nil
+		}
+		if *exportKustomize {
+			if err := writeKustomizeBases(cmd, outDir, paths, docs, outputFormat); err != nil {
+				return err
+			}
+			paths = nil
+		}
+		for _, p := range paths {
+			out, err := joinDocuments(docs[p], outputFormat, false)
+			if err != nil {
+				return err
+			}
+			if err := writeExportFile(cmd, path.Join(outDir, p), out); err != nil {
+				return err
+			}
+		}
+		if skipped > 0 {
+			cmd.PrintErrf("skipped %d objects owned by a controller, use --include-owned to include them\n", skipped)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d exports failed", failed)
+		}
+		return nil
+	},
+}
+
+// writeExportFile writes an exported file, creating its directory, and prints its path
+func writeExportFile(cmd *cobra.Command, file string, data []byte) error {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return err
+	}
+	cmd.Println(file)
+	return nil
+}
+
+// writeKustomizeBases writes the objects of each top level directory of the by-namespace layout as a kustomize base,
+// with its kustomization.yaml. 'Cluster' gets one without a namespace
+func writeKustomizeBases(cmd *cobra.Command, outDir string, paths []string, docs map[string][][]byte, format string) error {
+	var dirs []string
+	resources := map[string][]kustomize.Resource{}
+	for _, p := range paths {
+		dir, rel, _ := s.Cut(p, "/")
+		if _, ok := resources[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		for _, d := range docs[p] {
+			resources[dir] = append(resources[dir], kustomize.Resource{Path: rel, JSON: d})
+		}
+	}
+	opts := kustomize.Options{CommonLabels: *kustomizeCommonLabels, Generators: *kustomizeGenerators}
+	for _, dir := range dirs {
+		namespace := dir
+		if dir == "Cluster" {
+			namespace = ""
+		}
+		base, err := kustomize.Build(namespace, resources[dir], opts)
+		if err != nil {
+			return fmt.Errorf("error building the kustomization of %s : %v", dir, err)
+		}
+		if err := writeExportFile(cmd, path.Join(outDir, dir, "kustomization.yaml"), base.Kustomization); err != nil {
+			return err
+		}
+		for _, r := range base.Resources {
+			out, err := joinDocuments([][]byte{r.JSON}, format, false)
+			if err != nil {
+				return err
+			}
+			if err := writeExportFile(cmd, path.Join(outDir, dir, r.Path), out); err != nil {
+				return err
+			}
+		}
+		for _, f := range base.Files {
+			if err := writeExportFile(cmd, path.Join(outDir, dir, f.Path), f.Data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sortDocuments puts json objects in dependency order
+func sortDocuments(docs [][]byte) [][]byte {
+	var objects []string
+	for _, d := range docs {
+		objects = append(objects, string(d))
+	}
+	var sorted [][]byte
+	for _, i := range order.Sort(objects) {
+		sorted = append(sorted, docs[i])
+	}
+	return sorted
+}
+
+// joinDocuments renders json objects in the output format, in dependency order: a single object as is,
+// several ones as `---` separated yaml documents or a json v1 List. 'list' makes a List of a single json object too
+func joinDocuments(docs [][]byte, format string, list bool) ([]byte, error) {
+	sorted := sortDocuments(docs)
+	if format == "json" {
+		if len(sorted) == 1 && !list {
+			return sorted[0], nil
+		}
+		items := []json.RawMessage{}
+		for _, d := range sorted {
+			items = append(items, d)
+		}
+		return json.Marshal(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"metadata":   map[string]interface{}{"resourceVersion": ""},
+			"items":      items,
+		})
+	}
+	var out []byte
+	for i, d := range sorted {
+		y, err := yaml.JSONToYAML(d)
+		if err != nil {
+			return nil, fmt.Errorf("error converting from json to yaml : %v", err)
+		}
+		if i > 0 {
+			out = append(out, "---\n"...)
+		}
+		out = append(out, y...)
+	}
+	return out, nil
+}
@@ -0,0 +1,636 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/Baiyuani/kubectl-neatx/pkg/secrets"
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func configMap(ns string, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace(ns)
+	obj.SetName(name)
+	obj.SetResourceVersion("1")
+	obj.SetUID("c0ffee")
+	unstructured.SetNestedField(obj.Object, name, "data", "name")
+	return obj
+}
+
+// object returns a bare object of the given kind, 'ns' is empty for cluster scoped kinds
+func object(apiVersion, kind, ns, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(ns)
+	obj.SetName(name)
+	return obj
+}
+
+// runExportCmd runs export into a new directory and returns its output and the exported files with their content
+func runExportCmd(t *testing.T, namespaces string, concurrency int, args ...string) (string, map[string]string, error) {
+	dir := t.TempDir()
+	origDir, origNs, origConcurrency, origOutput := *exportOutDir, *kubeConfigFlags.Namespace, *exportConcurrency, *outputFormat
+	defer func() {
+		*exportOutDir, *kubeConfigFlags.Namespace, *exportConcurrency, *outputFormat = origDir, origNs, origConcurrency, origOutput
+	}()
+	*exportOutDir, *kubeConfigFlags.Namespace, *exportConcurrency, *outputFormat = dir, namespaces, concurrency, "yaml"
+
+	cmdout := new(bytes.Buffer)
+	rootCmd.SetOut(cmdout)
+	rootCmd.SetErr(cmdout)
+	defer rootCmd.SetOut(os.Stdout)
+	defer rootCmd.SetErr(os.Stderr)
+	err := exportCmd.RunE(exportCmd, args)
+
+	files := map[string]string{}
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			data, _ := os.ReadFile(p)
+			rel, _ := filepath.Rel(dir, p)
+			files[rel] = string(data)
+		}
+		return nil
+	})
+	return strings.ReplaceAll(cmdout.String(), dir, "<dir>"), files, err
+}
+
+func TestExportCmd(t *testing.T) {
+	var objects []runtime.Object
+	for _, ns := range []string{"ns1", "ns2"} {
+		for i := 0; i < 50; i++ {
+			objects = append(objects, configMap(ns, fmt.Sprintf("cm-%02d", i)))
+		}
+	}
+	pv := fixtureObject(t, "../test/fixtures/pv1-raw.json")
+	objects = append(objects, pv)
+	defer fakeClients(objects...)()
+
+	wantOut, wantFiles, err := runExportCmd(t, "ns1,ns2", 1, "cm,pv")
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	if len(wantFiles) != 101 {
+		t.Errorf("want 101 files, have %d", len(wantFiles))
+	}
+	pvFile := filepath.Join("Cluster", "persistentvolumes", pv.GetName()+".yaml")
+	if _, ok := wantFiles[pvFile]; !ok {
+		t.Errorf("missing cluster scoped file %s", pvFile)
+	}
+	cm := wantFiles[filepath.Join("ns2", "configmaps", "cm-07.yaml")]
+	if strings.Contains(cm, "resourceVersion") || !strings.Contains(cm, "name: cm-07") {
+		t.Errorf("configmap not neated: %s", cm)
+	}
+
+	// the output doesn't depend on scheduling
+	for _, concurrency := range []int{4, 16} {
+		haveOut, haveFiles, err := runExportCmd(t, "ns1,ns2", concurrency, "cm,pv")
+		if err != nil {
+			t.Fatalf("error exporting: %v", err)
+		}
+		if haveOut != wantOut {
+			t.Errorf("concurrency %d: want output: '%s' have: '%s'", concurrency, wantOut, haveOut)
+		}
+		for f, content := range wantFiles {
+			if haveFiles[f] != content {
+				t.Errorf("concurrency %d: file %s differs. want: '%s' have: '%s'", concurrency, f, content, haveFiles[f])
+			}
+		}
+	}
+}
+
+func TestExportSkipsOwned(t *testing.T) {
+	owned := func(obj *unstructured.Unstructured, apiVersion, kind, name string) *unstructured.Unstructured {
+		controller := true
+		obj.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, UID: "1", Controller: &controller}})
+		return obj
+	}
+	objects := []runtime.Object{
+		object("apps/v1", "Deployment", "ns1", "web"),
+		owned(object("apps/v1", "ReplicaSet", "ns1", "web-5d4f"), "apps/v1", "Deployment", "web"),
+		owned(object("v1", "Pod", "ns1", "web-5d4f-abc"), "apps/v1", "ReplicaSet", "web-5d4f"),
+		owned(object("v1", "ConfigMap", "ns1", "not-a-controller"), "example.com/v1", "Unknown", "u"),
+	}
+	defer fakeClients(objects...)()
+
+	cases := []struct {
+		kinds        string
+		includeOwned bool
+		want         []string
+		skipped      int
+	}{
+		{"deploy,rs,pods,cm", false, []string{"ns1/deployments.apps/web.yaml", "ns1/configmaps/not-a-controller.yaml"}, 2},
+		{"deploy,rs,pods,cm", true, []string{"ns1/deployments.apps/web.yaml", "ns1/replicasets.apps/web-5d4f.yaml", "ns1/pods/web-5d4f-abc.yaml", "ns1/configmaps/not-a-controller.yaml"}, 0},
+		// controllers that aren't exported don't make their objects skipped
+		{"pods", false, []string{"ns1/pods/web-5d4f-abc.yaml"}, 0},
+		{"rs,pods", false, []string{"ns1/replicasets.apps/web-5d4f.yaml"}, 1},
+	}
+	defer func() { includeOwned = false }()
+	for _, c := range cases {
+		includeOwned = c.includeOwned
+		out, files, err := runExportCmd(t, "ns1", 2, c.kinds)
+		if err != nil {
+			t.Fatalf("error exporting: %v", err)
+		}
+		if len(files) != len(c.want) {
+			t.Errorf("%s include owned %v: want: '%v' have: '%v'", c.kinds, c.includeOwned, c.want, files)
+		}
+		for _, f := range c.want {
+			if _, ok := files[filepath.FromSlash(f)]; !ok {
+				t.Errorf("%s include owned %v: missing %s in '%v'", c.kinds, c.includeOwned, f, files)
+			}
+		}
+		report := fmt.Sprintf("skipped %d objects owned by a controller", c.skipped)
+		if c.skipped > 0 != strings.Contains(out, report) || (c.skipped == 0 && strings.Contains(out, "skipped")) {
+			t.Errorf("%s include owned %v: want %d skipped objects reported, have: %s", c.kinds, c.includeOwned, c.skipped, out)
+		}
+	}
+}
+
+func TestExportAllKinds(t *testing.T) {
+	objects := []runtime.Object{
+		configMap("ns1", "app"),
+		object("apps/v1", "Deployment", "ns1", "web"),
+		object("v1", "Event", "ns1", "web.17a"),
+		object("v1", "Endpoints", "ns1", "web"),
+		object("coordination.k8s.io/v1", "Lease", "ns1", "leader"),
+		object("rbac.authorization.k8s.io/v1", "ClusterRole", "", "reader"),
+		object("v1", "Node", "", "node1"),
+	}
+	defer fakeClients(objects...)()
+
+	cases := []struct {
+		allKinds      bool
+		clusterScoped bool
+		include       []string
+		exclude       []string
+		want          []string
+	}{
+		{true, false, nil, nil, []string{"ns1/configmaps/app.yaml", "ns1/deployments.apps/web.yaml"}},
+		{true, false, []string{"ev,leases"}, []string{"cm"}, []string{"ns1/deployments.apps/web.yaml", "ns1/events/web.17a.yaml", "ns1/leases.coordination.k8s.io/leader.yaml"}},
+		{false, true, nil, nil, []string{"Cluster/clusterroles.rbac.authorization.k8s.io/reader.yaml"}},
+		{true, true, []string{"no"}, []string{"deploy"}, []string{"ns1/configmaps/app.yaml", "Cluster/clusterroles.rbac.authorization.k8s.io/reader.yaml", "Cluster/nodes/node1.yaml"}},
+	}
+	defer func() { *allKinds, *clusterScoped, *includeKinds, *excludeKinds = false, false, nil, nil }()
+	for _, c := range cases {
+		*allKinds, *clusterScoped, *includeKinds, *excludeKinds = c.allKinds, c.clusterScoped, c.include, c.exclude
+		_, files, err := runExportCmd(t, "ns1", 2)
+		if err != nil {
+			t.Fatalf("error exporting: %v", err)
+		}
+		if len(files) != len(c.want) {
+			t.Errorf("test case '%v' failed. want: '%v' have: '%v'", c, c.want, files)
+		}
+		for _, f := range c.want {
+			if _, ok := files[filepath.FromSlash(f)]; !ok {
+				t.Errorf("test case '%v' failed. missing %s in '%v'", c, f, files)
+			}
+		}
+	}
+
+	*allKinds = true
+	if _, _, err := runExportCmd(t, "ns1", 2, "cm"); err == nil {
+		t.Errorf("want error when kinds are given with --all-kinds")
+	}
+}
+
+func TestExportSelectors(t *testing.T) {
+	labeled := func(obj *unstructured.Unstructured, app string) *unstructured.Unstructured {
+		obj.SetLabels(map[string]string{"app": app})
+		return obj
+	}
+	pv := fixtureObject(t, "../test/fixtures/pv1-raw.json")
+	objects := []runtime.Object{
+		labeled(configMap("ns1", "payments"), "payments"),
+		labeled(configMap("ns1", "orders"), "orders"),
+		labeled(configMap("ns2", "payments"), "payments"),
+		pv,
+	}
+	c := fakeCluster(objects...)
+	orig := newClients
+	newClients = func(flags *genericclioptions.ConfigFlags) (*clients, error) {
+		return c, nil
+	}
+	defer func() { newClients = orig }()
+	defer func() { labelSelector, fieldSelector = "", "" }()
+
+	labelSelector, fieldSelector = "app=payments", "metadata.name!=kube-root-ca.crt"
+	_, files, err := runExportCmd(t, "ns1,ns2", 2, "cm,pv")
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	want := []string{"ns1/configmaps/payments.yaml", "ns2/configmaps/payments.yaml"}
+	if len(files) != len(want) {
+		t.Errorf("want: '%v' have: '%v'", want, files)
+	}
+	for _, f := range want {
+		if _, ok := files[filepath.FromSlash(f)]; !ok {
+			t.Errorf("missing %s in '%v'", f, files)
+		}
+	}
+
+	// the selectors are sent with every list call, cluster scoped ones included
+	lists := 0
+	for _, a := range c.dynamic.(*dynamicfake.FakeDynamicClient).Actions() {
+		list, ok := a.(k8stesting.ListAction)
+		if !ok {
+			continue
+		}
+		lists++
+		r := list.GetListRestrictions()
+		if r.Labels.String() != labelSelector || r.Fields.String() != fieldSelector {
+			t.Errorf("list of %s in '%s' without the selectors: '%s' '%s'", list.GetResource().Resource, list.GetNamespace(), r.Labels, r.Fields)
+		}
+		if list.GetResource().Resource == "persistentvolumes" && list.GetNamespace() != "" {
+			t.Errorf("cluster scoped list in namespace '%s'", list.GetNamespace())
+		}
+	}
+	if lists != 3 {
+		t.Errorf("want 3 list calls, have %d", lists)
+	}
+}
+
+func TestExportLayout(t *testing.T) {
+	objects := []runtime.Object{configMap("ns1", "app"), configMap("ns2", "app"), fixtureObject(t, "../test/fixtures/pv1-raw.json")}
+	defer fakeClients(objects...)()
+	pv := "pvc-54fad2fe-4d7b-11e9-9172-0800271788ca"
+
+	cases := []struct {
+		layout string
+		args   string
+		want   []string
+	}{
+		{"by-namespace", "cm,pv", []string{"ns1/configmaps/app.yaml", "ns2/configmaps/app.yaml", "Cluster/persistentvolumes/" + pv + ".yaml"}},
+		// the layout doesn't depend on how the kind is typed
+		{"by-namespace", "configmap,persistentvolumes", []string{"ns1/configmaps/app.yaml", "ns2/configmaps/app.yaml", "Cluster/persistentvolumes/" + pv + ".yaml"}},
+		{"by-kind", "cm,pv", []string{"configmaps/ns1/app.yaml", "configmaps/ns2/app.yaml", "persistentvolumes/" + pv + ".yaml"}},
+		{"flat", "cm,pv", []string{"ns1-configmaps-app.yaml", "ns2-configmaps-app.yaml", "persistentvolumes-" + pv + ".yaml"}},
+		{"single-file", "cm,pv", []string{"all.yaml"}},
+		{"{{.Namespace}}/{{.Kind | lower}}-{{.Name}}.yaml", "cm,pv", []string{"ns1/configmap-app.yaml", "ns2/configmap-app.yaml", "persistentvolume-" + pv + ".yaml"}},
+	}
+	defer func() { *exportLayout = "by-namespace" }()
+	for _, c := range cases {
+		*exportLayout = c.layout
+		_, files, err := runExportCmd(t, "ns1,ns2", 2, c.args)
+		if err != nil {
+			t.Fatalf("error exporting: %v", err)
+		}
+		if len(files) != len(c.want) {
+			t.Errorf("test case '%s' failed. want: '%v' have: '%v'", c.layout, c.want, files)
+		}
+		for _, f := range c.want {
+			if _, ok := files[filepath.FromSlash(f)]; !ok {
+				t.Errorf("test case '%s' failed. missing %s in '%v'", c.layout, f, files)
+			}
+		}
+		if c.layout == "single-file" && strings.Count(files["all.yaml"], "---\n") != 2 {
+			t.Errorf("test case '%s' failed. want 3 documents have: '%s'", c.layout, files["all.yaml"])
+		}
+	}
+
+	*exportLayout = "../{{.Name}}"
+	if _, _, err := runExportCmd(t, "ns1", 2, "cm"); err == nil {
+		t.Errorf("want error for a layout outside the destination directory")
+	}
+}
+
+func TestExportSingleFile(t *testing.T) {
+	deploy := &unstructured.Unstructured{}
+	deploy.SetAPIVersion("apps/v1")
+	deploy.SetKind("Deployment")
+	deploy.SetNamespace("ns1")
+	deploy.SetName("web")
+	unstructured.SetNestedSlice(deploy.Object, []interface{}{map[string]interface{}{"name": "config", "configMap": map[string]interface{}{"name": "app"}}}, "spec", "template", "spec", "volumes")
+	defer fakeClients(deploy, configMap("ns1", "app"))()
+	defer func() { *exportSingleFile = "" }()
+
+	// the config map comes first since the deployment mounts it
+	*exportSingleFile = "-"
+	out, files, err := runExportCmd(t, "ns1", 2, "deploy,cm")
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("want no files have: '%v'", files)
+	}
+	docs := strings.Split(out, "---\n")
+	if len(docs) != 2 || !strings.Contains(docs[0], "kind: ConfigMap") || !strings.Contains(docs[1], "kind: Deployment") {
+		t.Errorf("want the config map then the deployment have: '%s'", out)
+	}
+
+	*exportSingleFile = filepath.Join(t.TempDir(), "out", "app1.yaml")
+	out, _, err = runExportCmd(t, "ns1", 2, "deploy,cm")
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	data, _ := os.ReadFile(*exportSingleFile)
+	if strings.Count(string(data), "---\n") != 1 || !strings.Contains(out, "app1.yaml") {
+		t.Errorf("want 2 documents in the file have: '%s' output: '%s'", data, out)
+	}
+}
+
+func TestJoinDocuments(t *testing.T) {
+	cm := []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"app","namespace":"ns1"}}`)
+	ns := []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"ns1"}}`)
+	cases := []struct {
+		title  string
+		docs   [][]byte
+		format string
+		list   bool
+		expect string
+	}{
+		{"single json", [][]byte{cm}, "json", false, string(cm)},
+		{"single json list", [][]byte{cm}, "json", true, `{"apiVersion":"v1","kind":"List","metadata":{"resourceVersion":""},"items":[` + string(cm) + `]}`},
+		{"json list in order", [][]byte{cm, ns}, "json", false, `{"apiVersion":"v1","kind":"List","metadata":{"resourceVersion":""},"items":[` + string(ns) + `,` + string(cm) + `]}`},
+	}
+	for _, c := range cases {
+		res, err := joinDocuments(c.docs, c.format, c.list)
+		if err != nil {
+			t.Errorf("test case '%s' failed. error: %v", c.title, err)
+			continue
+		}
+		equal, err := testutil.JSONEqual(string(res), c.expect)
+		if err != nil || !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, res)
+		}
+	}
+
+	res, err := joinDocuments([][]byte{cm, ns}, "yaml", false)
+	if err != nil || !strings.HasPrefix(string(res), "apiVersion: v1\nkind: Namespace\n") || strings.Count(string(res), "---\n") != 1 {
+		t.Errorf("want the namespace then the config map have: '%s' %v", res, err)
+	}
+}
+
+func TestExportKustomize(t *testing.T) {
+	cm := configMap("ns1", "app")
+	cm.SetLabels(map[string]string{"team": "payments"})
+	deploy := &unstructured.Unstructured{}
+	deploy.SetAPIVersion("apps/v1")
+	deploy.SetKind("Deployment")
+	deploy.SetNamespace("ns1")
+	deploy.SetName("web")
+	deploy.SetLabels(map[string]string{"team": "payments", "app": "web"})
+	defer fakeClients(cm, deploy, fixtureObject(t, "../test/fixtures/pv1-raw.json"))()
+	defer func() { *exportKustomize, *kustomizeCommonLabels, *kustomizeGenerators = false, false, false }()
+
+	*exportKustomize, *kustomizeCommonLabels, *kustomizeGenerators = true, true, true
+	_, files, err := runExportCmd(t, "ns1", 2, "cm,deploy,pv")
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	want := map[string]string{
+		"ns1/kustomization.yaml": `apiVersion: kustomize.config.k8s.io/v1beta1
+commonLabels:
+  team: payments
+configMapGenerator:
+- files:
+  - configmaps/app/name
+  name: app
+  options:
+    disableNameSuffixHash: true
+kind: Kustomization
+namespace: ns1
+resources:
+- deployments.apps/web.yaml
+`,
+		"ns1/configmaps/app/name": "app",
+		"ns1/deployments.apps/web.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app: web
+  name: web
+  namespace: ns1
+`,
+		"Cluster/kustomization.yaml": `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+- persistentvolumes/pvc-54fad2fe-4d7b-11e9-9172-0800271788ca.yaml
+`,
+	}
+	if len(files) != len(want)+1 {
+		t.Errorf("want: '%v' have: '%v'", want, files)
+	}
+	for f, content := range want {
+		if files[filepath.FromSlash(f)] != content {
+			t.Errorf("file %s differs. want: '%s' have: '%s'", f, content, files[filepath.FromSlash(f)])
+		}
+	}
+
+	*exportSingleFile = "-"
+	defer func() { *exportSingleFile = "" }()
+	if _, _, err := runExportCmd(t, "ns1", 2, "cm"); err == nil {
+		t.Errorf("want error for --kustomize with --single-file")
+	}
+}
+
+func TestExportHelmChart(t *testing.T) {
+	deploy := &unstructured.Unstructured{}
+	deploy.SetAPIVersion("apps/v1")
+	deploy.SetKind("Deployment")
+	deploy.SetNamespace("ns1")
+	deploy.SetName("web")
+	unstructured.SetNestedField(deploy.Object, int64(2), "spec", "replicas")
+	unstructured.SetNestedSlice(deploy.Object, []interface{}{map[string]interface{}{"name": "web", "image": "nginx:1.25"}}, "spec", "template", "spec", "containers")
+	defer fakeClients(deploy, configMap("ns1", "app"))()
+	defer func() { *exportHelmChart = "" }()
+
+	*exportHelmChart = "web"
+	_, files, err := runExportCmd(t, "ns1", 2, "deploy,cm")
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	want := map[string]string{
+		"web/values.yaml": `configMaps:
+  app:
+    name: app
+web:
+  containers:
+    web:
+      image:
+        repository: nginx
+        tag: "1.25"
+  replicas: 2
+`,
+		"web/templates/configmap-app.yaml": `apiVersion: v1
+data:
+  name: {{ index .Values.configMaps "app" "name" | toJson }}
+kind: ConfigMap
+metadata:
+  name: app
+`,
+	}
+	for f, content := range want {
+		if files[filepath.FromSlash(f)] != content {
+			t.Errorf("file %s differs. want: '%s' have: '%s'", f, content, files[filepath.FromSlash(f)])
+		}
+	}
+	for _, f := range []string{"web/Chart.yaml", "web/templates/deployment-web.yaml"} {
+		if _, ok := files[filepath.FromSlash(f)]; !ok {
+			t.Errorf("missing %s in '%v'", f, files)
+		}
+	}
+}
+
+func TestExportSecrets(t *testing.T) {
+	secret := fixtureObject(t, "../test/fixtures/secret1-raw.json")
+	secret.SetNamespace("ns1")
+	defer fakeClients(secret, configMap("ns1", "app"))()
+	defer func() { secretPolicy = nil }()
+
+	secretPolicy = &secrets.Policy{Mode: secrets.Omit}
+	_, files, err := runExportCmd(t, "ns1", 2, "cm,secrets")
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	if len(files) != 1 || files[filepath.Join("ns1", "configmaps", "app.yaml")] == "" {
+		t.Errorf("want only the config map have: '%v'", files)
+	}
+
+	// encrypted values stay valid base64, so secret generators get the armored files
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretPolicy, err = secrets.NewPolicy("encrypt", []string{identity.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { *exportKustomize, *kustomizeGenerators = false, false }()
+	*exportKustomize, *kustomizeGenerators = true, true
+	_, files, err = runExportCmd(t, "ns1", 2, "secrets")
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	armored := files[filepath.Join("ns1", "secrets", "myreg", ".dockerconfigjson")]
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(armored)), identity)
+	if err != nil {
+		t.Fatalf("error decrypting the generator file: %v\nfiles: %v", err, files)
+	}
+	plain, _ := io.ReadAll(r)
+	if want := `{"auths":{"myregistry.test":{"username":"user","password":"pass","email":"user@email.test","auth":"dXNlcjpwYXNz"}}}`; string(plain) != want {
+		t.Errorf("want: '%s' have: '%s'", want, plain)
+	}
+}
+
+func TestExportImageRewrite(t *testing.T) {
+	deploy := &unstructured.Unstructured{}
+	deploy.SetAPIVersion("apps/v1")
+	deploy.SetKind("Deployment")
+	deploy.SetNamespace("ns1")
+	deploy.SetName("web")
+	unstructured.SetNestedSlice(deploy.Object, []interface{}{map[string]interface{}{"name": "web", "image": "nginx:1.25"}}, "spec", "template", "spec", "containers")
+	unstructured.SetNestedSlice(deploy.Object, []interface{}{map[string]interface{}{"name": "init", "image": "quay.io/org/init:1"}}, "spec", "template", "spec", "initContainers")
+	defer fakeClients(deploy)()
+	defer func() { imageRewriteRules, imageRewriteFile = nil, "" }()
+
+	imageRewriteRules = []string{"docker.io/=registry.local/dockerhub/"}
+	imageRewriteFile = filepath.Join(t.TempDir(), "images.txt")
+	os.WriteFile(imageRewriteFile, []byte(`regex:^quay\.io/(.*)=registry.local/quay/$1`+"\n"), 0644)
+	out, files, err := runExportCmd(t, "ns1", 2, "deploy")
+	if err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+	manifest := files[filepath.Join("ns1", "deployments.apps", "web.yaml")]
+	for _, want := range []string{"image: registry.local/dockerhub/library/nginx:1.25", "image: registry.local/quay/org/init:1"} {
+		if !strings.Contains(manifest, want) {
+			t.Errorf("want '%s' in: '%s'", want, manifest)
+		}
+	}
+	for _, want := range []string{
+		"image rewritten: Deployment ns1/web container init: quay.io/org/init:1 -> registry.local/quay/org/init:1",
+		"image rewritten: Deployment ns1/web container web: nginx:1.25 -> registry.local/dockerhub/library/nginx:1.25",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want '%s' in the report: '%s'", want, out)
+		}
+	}
+}
+
+func TestExportStorageClassMap(t *testing.T) {
+	pv := fixtureObject(t, "../test/fixtures/pv1-raw.json")
+	pvc := &unstructured.Unstructured{}
+	pvc.SetAPIVersion("v1")
+	pvc.SetKind("PersistentVolumeClaim")
+	pvc.SetNamespace("default")
+	pvc.SetName("prom-prometheus-alertmanager")
+	pvc.SetAnnotations(map[string]string{"volume.kubernetes.io/storage-provisioner": "k8s.io/minikube-hostpath"})
+	unstructured.SetNestedField(pvc.Object, "standard", "spec", "storageClassName")
+	unstructured.SetNestedField(pvc.Object, pv.GetName(), "spec", "volumeName")
+	// a claim of a class that isn't mapped stays bound to its volume
+	nfs := object("v1", "PersistentVolumeClaim", "default", "shared")
+	nfs.SetAnnotations(map[string]string{"volume.kubernetes.io/storage-provisioner": "nfs.csi.k8s.io"})
+	unstructured.SetNestedField(nfs.Object, "nfs", "spec", "storageClassName")
+	unstructured.SetNestedField(nfs.Object, "pvc-nfs", "spec", "volumeName")
+	defer fakeClients(pv, pvc, nfs)()
+	defer func() { storageClassPairs, preservePVBinding = nil, false }()
+
+	pvFile := filepath.Join("Cluster", "persistentvolumes", pv.GetName()+".yaml")
+	pvcFile := filepath.Join("default", "persistentvolumeclaims", pvc.GetName()+".yaml")
+	nfsFile := filepath.Join("default", "persistentvolumeclaims", "shared.yaml")
+	cases := []struct {
+		preserve bool
+		want     map[string][]string
+		notWant  map[string][]string
+	}{
+		{
+			preserve: false,
+			want: map[string][]string{
+				pvFile:  {"storageClassName: fast"},
+				pvcFile: {"storageClassName: fast"},
+				nfsFile: {"storageClassName: nfs", "volumeName: pvc-nfs", "volume.kubernetes.io/storage-provisioner: nfs.csi.k8s.io"},
+			},
+			notWant: map[string][]string{pvFile: {"claimRef"}, pvcFile: {"volumeName", "storage-provisioner"}},
+		},
+		{
+			preserve: true,
+			want: map[string][]string{
+				pvFile:  {"storageClassName: fast", "claimRef:\n    kind: PersistentVolumeClaim\n    name: prom-prometheus-alertmanager\n    namespace: default\n"},
+				pvcFile: {"storageClassName: fast", "volumeName: " + pv.GetName()},
+			},
+			notWant: map[string][]string{pvFile: {"uid"}},
+		},
+	}
+	for _, c := range cases {
+		storageClassPairs, preservePVBinding = []string{"standard=fast"}, c.preserve
+		_, files, err := runExportCmd(t, "default", 1, "pv,pvc")
+		if err != nil {
+			t.Fatalf("error exporting: %v", err)
+		}
+		for f, wants := range c.want {
+			for _, want := range wants {
+				if !strings.Contains(files[f], want) {
+					t.Errorf("preserve %v: want '%s' in %s: '%s'", c.preserve, want, f, files[f])
+				}
+			}
+		}
+		for f, notWants := range c.notWant {
+			for _, notWant := range notWants {
+				if strings.Contains(files[f], notWant) {
+					t.Errorf("preserve %v: don't want '%s' in %s: '%s'", c.preserve, notWant, f, files[f])
+				}
+			}
+		}
+	}
+
+	storageClassPairs = []string{"standard"}
+	if _, _, err := runExportCmd(t, "default", 1, "pvc"); err == nil {
+		t.Errorf("want error for an invalid --storage-class-map")
+	}
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	s "strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// manifestExtensions are the extensions of the files read from directories
+var manifestExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+// inputPaths expands the -f values into the files to neat: globs are matched, and directories give their
+// manifest files, or those of their subdirectories too with 'recursive'. - stands for stdin
+func inputPaths(values []string, recursive bool) ([]string, error) {
+	var res []string
+	seen := map[string]bool{}
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			res = append(res, p)
+		}
+	}
+	for _, v := range values {
+		if v == "-" {
+			add(v)
+			continue
+		}
+		matches := []string{v}
+		if s.ContainsAny(v, "*?[") {
+			var err error
+			matches, err = filepath.Glob(v)
+			if err != nil {
+				return nil, fmt.Errorf("error in pattern '%s' : %v", v, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match '%s'", v)
+			}
+		}
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(m)
+				continue
+			}
+			files, err := manifestFiles(m, recursive)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range files {
+				add(f)
+			}
+		}
+	}
+	return res, nil
+}
+
+// manifestFiles lists the yaml and json files of a directory, in lexical order
+func manifestFiles(dir string, recursive bool) ([]string, error) {
+	var res []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if manifestExtensions[s.ToLower(filepath.Ext(p))] {
+			res = append(res, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// documentWriter writes neated documents one after the other, separated by --- for yaml and a new line for json
+type documentWriter struct {
+	out     io.Writer
+	written int
+	// lastYAML and lastNewline describe the last document written
+	lastYAML    bool
+	lastNewline bool
+}
+
+func (w *documentWriter) write(doc []byte, itsYaml bool) error {
+	if w.written > 0 {
+		separator := ""
+		if !w.lastNewline {
+			separator = "\n"
+		}
+		if itsYaml || w.lastYAML {
+			separator += "---\n"
+		}
+		if _, err := io.WriteString(w.out, separator); err != nil {
+			return err
+		}
+	}
+	if _, err := w.out.Write(doc); err != nil {
+		return err
+	}
+	w.written++
+	w.lastYAML = itsYaml
+	w.lastNewline = bytes.HasSuffix(doc, []byte("\n"))
+	return nil
+}
+
+// neatFiles neats the files, - being stdin, to the output of the command
+func neatFiles(cmd *cobra.Command, paths []string, outputFormat string) error {
+	w := &documentWriter{out: cmd.OutOrStdout()}
+	for _, p := range paths {
+		if p == "-" {
+			if err := neatStream(cmd.InOrStdin(), w, outputFormat); err != nil {
+				return err
+			}
+			continue
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		err = neatStream(f, w, outputFormat)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error in %s : %v", p, err)
+		}
+	}
+	return nil
+}
+
+// neatInPlace rewrites each file with its neated content, in the format it's written in.
+// with a backup suffix, the original content is kept next to the file first
+func neatInPlace(cmd *cobra.Command, paths []string, backupSuffix string) error {
+	for _, p := range paths {
+		if p == "-" {
+			return fmt.Errorf("--in-place rewrites files, it can't read stdin")
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		in, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		format := "yaml"
+		if isJSON(in) {
+			format = "json"
+		}
+		var out bytes.Buffer
+		w := &documentWriter{out: &out}
+		if err := neatStream(bytes.NewReader(in), w, format); err != nil {
+			return fmt.Errorf("error in %s : %v", p, err)
+		}
+		if w.written == 0 && len(bytes.TrimSpace(in)) > 0 {
+			// e.g. a Secret with --secrets=omit, an empty file would lose it
+			log.Warnf("leaving %s untouched, every object in it is omitted", p)
+			continue
+		}
+		if bytes.Equal(out.Bytes(), in) {
+			continue
+		}
+		if backupSuffix != "" {
+			if err := os.WriteFile(p+backupSuffix, in, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(p, out.Bytes(), info.Mode().Perm()); err != nil {
+			return err
+		}
+		cmd.Println(p)
+	}
+	return nil
+}
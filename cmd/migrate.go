@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	s "strings"
+	"time"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/order"
+	"github.com/Baiyuani/kubectl-neatx/pkg/resources"
+	"github.com/ghodss/yaml"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// fieldManager is the field manager migrate applies objects as
+const fieldManager = "kubectl-neatx"
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate resources between clusters",
+	Example: `kubectl neatx migrate --source-context=ctx1 --target-context=ctx2 deploy/myapp svc/myapp -n default
+kubectl neatx migrate --source-context=ctx1 --target-context=ctx2 deploy,svc,cm -n app1,app2 -l app=myapp
+kubectl neatx migrate --source-context=ctx1 --target-context=ctx2 --all -n default
+kubectl neatx migrate --source-context=ctx1 --target-context=ctx2 secrets -A
+kubectl neatx migrate --source-context=ctx1 --target-context=ctx2 --all -n team-a-staging --namespace-map team-a-staging=team-a-prod
+kubectl neatx migrate --source-context=ctx1 --target-context=ctx2 deploy,svc -n default --dry-run=server
+kubectl neatx migrate --source-context=ctx1 --target-context=ctx2 deploy,svc -n default --diff`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sourceContext := cmd.Flag("source-context").Value.String()
+		targetContext := cmd.Flag("target-context").Value.String()
+		ctx := cmdContext(cmd)
+
+		if *migrateDryRun != "none" && *migrateDryRun != "client" && *migrateDryRun != "server" {
+			return fmt.Errorf("invalid --dry-run value '%s', must be none, client or server", *migrateDryRun)
+		}
+		if *migrateDiff && *migrateDryRun != "none" {
+			return fmt.Errorf("--diff doesn't apply anything, it can't be combined with --dry-run")
+		}
+		if *migrateAll && len(args) > 0 {
+			return fmt.Errorf("--all migrates every kind, it can't be combined with resource arguments")
+		}
+		var targets []getTarget
+		if !*migrateAll {
+			ga, err := parseGetArgs(args)
+			if err != nil {
+				return err
+			}
+			targets = ga.targets
+		}
+		if err := loadNamespaceMap(namespaceMapPairs, namespaceMapFile); err != nil {
+			return err
+		}
+		if err := loadImageMap(imageRewriteRules, imageRewriteFile); err != nil {
+			return err
+		}
+		if err := loadStorageMap(storageClassPairs, preservePVBinding); err != nil {
+			return err
+		}
+		source, err := newClients(flagsForContext(kubeConfigFlags, sourceContext))
+		if err != nil {
+			return err
+		}
+		target, err := newClients(flagsForContext(kubeConfigFlags, targetContext))
+		if err != nil {
+			return err
+		}
+		if *migrateAll {
+			targets, err = exportableTargets(source, true, splitKinds(*includeKinds), splitKinds(*excludeKinds))
+			if err != nil {
+				return err
+			}
+		}
+
+		// Get and neat resources from source cluster
+		namespaces := source.namespaces()
+		if *migrateAllNamespaces {
+			namespaces = []string{metav1.NamespaceAll}
+		}
+		jobs, err := exportJobs(source, targets, namespaces, selectors())
+		if err != nil {
+			return err
+		}
+		results := runExport(ctx, source, jobs, *exportConcurrency)
+
+		failed, migrated, skipped := 0, 0, 0
+		var objects []string
+		for _, res := range results {
+			if res.owner != "" {
+				skipped++
+				continue
+			}
+			if res.err != nil {
+				failed++
+				cmd.PrintErrln(res.errorString())
+				continue
+			}
+			for _, line := range res.report {
+				cmd.PrintErrln(line)
+			}
+			objects = append(objects, string(res.json))
+		}
+
+		// Apply to target cluster in dependency order, one object at a time
+		suffix := map[string]string{"none": "", "client": " (dry run)", "server": " (server dry run)"}[*migrateDryRun]
+		differ := 0
+		crds := map[schema.GroupKind]string{}
+		established := map[string]error{}
+		for _, i := range order.Sort(objects) {
+			obj := &unstructured.Unstructured{}
+			if err := obj.UnmarshalJSON([]byte(objects[i])); err != nil {
+				failed++
+				cmd.PrintErrf("error decoding object : %v\n", err)
+				continue
+			}
+			name := objectName(obj)
+			if obj.GroupVersionKind().GroupKind() == crdGroupKind {
+				for _, r := range resources.CRDResources(obj) {
+					crds[r.GroupVersionKind().GroupKind()] = obj.GetName()
+				}
+				target.resolver.Add(resources.CRDResources(obj)...)
+			}
+			if *migrateDiff {
+				d, err := target.diff(ctx, obj)
+				if err != nil {
+					failed++
+					cmd.PrintErrf("error diffing %s : %v\n", name, err)
+					continue
+				}
+				if d != "" {
+					differ++
+					cmd.Print(d)
+				}
+				continue
+			}
+			// custom resources wait for the CRD applied before them to be established
+			if crd, ok := crds[obj.GroupVersionKind().GroupKind()]; ok && *migrateDryRun == "none" {
+				if _, waited := established[crd]; !waited {
+					established[crd] = target.waitEstablished(ctx, crd, *migrateWaitTimeout)
+				}
+				if err := established[crd]; err != nil {
+					failed++
+					cmd.PrintErrf("error applying %s : %v\n", name, err)
+					continue
+				}
+			}
+			if *migrateDryRun != "client" {
+				if err := target.apply(ctx, obj, *migrateDryRun == "server"); err != nil {
+					failed++
+					cmd.PrintErrf("error applying %s : %v\n", name, err)
+					continue
+				}
+			}
+			migrated++
+			cmd.Printf("%s serverside-applied%s\n", name, suffix)
+		}
+		if skipped > 0 {
+			cmd.PrintErrf("skipped %d objects owned by a controller, use --include-owned to include them\n", skipped)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d objects migrated, %d failed", migrated, failed)
+		}
+		if differ > 0 {
+			return fmt.Errorf("%d objects differ from the target cluster", differ)
+		}
+		return nil
+	},
+}
+
+// crdGroupKind is the GroupKind of CustomResourceDefinitions
+var crdGroupKind = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+// crdPollInterval is how often the conditions of a CRD are checked while waiting for it
+var crdPollInterval = time.Second
+
+// objectName returns an object the way kubectl names it, e.g. deployment.apps/myapp
+func objectName(obj *unstructured.Unstructured) string {
+	return s.ToLower(obj.GroupVersionKind().GroupKind().String()) + "/" + obj.GetName()
+}
+
+// apply server-side applies 'obj', only as a dry run on the server if 'dryRun' is set
+func (c *clients) apply(ctx context.Context, obj *unstructured.Unstructured, dryRun bool) error {
+	r, err := c.resolver.ForKind(obj.GroupVersionKind())
+	if err != nil {
+		return err
+	}
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	ns := obj.GetNamespace()
+	if ns == "" && r.Namespaced {
+		ns = c.namespace
+	}
+	force := true
+	opts := metav1.PatchOptions{FieldManager: fieldManager, Force: &force}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	_, err = c.resourceInterface(r, ns).Patch(ctx, obj.GetName(), types.ApplyPatchType, data, opts)
+	return err
+}
+
+// diff returns the unified diff from the object in the cluster to 'obj', both neated the same way, or "" if they're the same.
+// objects missing from the cluster diff from nothing
+func (c *clients) diff(ctx context.Context, obj *unstructured.Unstructured) (string, error) {
+	r, err := c.resolver.ForKind(obj.GroupVersionKind())
+	if err != nil {
+		return "", err
+	}
+	ns := obj.GetNamespace()
+	if ns == "" && r.Namespaced {
+		ns = c.namespace
+	}
+	var live []byte
+	current, err := c.resourceInterface(r, ns).Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		data, err := current.MarshalJSON()
+		if err != nil {
+			return "", err
+		}
+		live, err = NeatYAMLOrJSON(data, "yaml")
+		if err != nil {
+			return "", err
+		}
+	}
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	incoming, err := yaml.JSONToYAML(data)
+	if err != nil {
+		return "", fmt.Errorf("error converting from json to yaml : %v", err)
+	}
+	if string(live) == string(incoming) {
+		return "", nil
+	}
+	var a []string
+	if len(live) > 0 {
+		a = difflib.SplitLines(s.TrimSuffix(string(live), "\n"))
+	}
+	where := path.Join(ns, objectName(obj))
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        difflib.SplitLines(s.TrimSuffix(string(incoming), "\n")),
+		FromFile: "target/" + where,
+		ToFile:   "source/" + where,
+		Context:  3,
+	})
+}
+
+// waitEstablished waits for the CustomResourceDefinition 'name' to have the Established condition
+func (c *clients) waitEstablished(ctx context.Context, name string, timeout time.Duration) error {
+	r, err := c.resolver.ForKind(crdGroupKind.WithVersion("v1"))
+	if err != nil {
+		return err
+	}
+	err = wait.PollUntilContextTimeout(ctx, crdPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		crd, err := c.resourceInterface(r, "").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+		for _, cond := range conditions {
+			cond, _ := cond.(map[string]interface{})
+			if cond["type"] == "Established" && cond["status"] == "True" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("CustomResourceDefinition %s isn't established : %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/resources"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// fakeMigration makes newClients return a fake source cluster holding 'objects' for context "src", and a fake
+// target cluster holding 'targetObjects' for context "dst" whose server-side applies are recorded in 'applied' as kind namespace/name.
+// CRDs applied to the target get established
+func fakeMigration(applied *[]string, objects []runtime.Object, targetObjects ...runtime.Object) func() {
+	source := fakeCluster(objects...)
+	for _, obj := range objects {
+		if u := obj.(*unstructured.Unstructured); u.GetKind() == "CustomResourceDefinition" {
+			source.resolver.Add(resources.CRDResources(u)...)
+		}
+	}
+	target := fakeCluster(targetObjects...)
+	fake := target.dynamic.(*dynamicfake.FakeDynamicClient)
+	fake.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		*applied = append(*applied, obj.GetKind()+" "+patch.GetNamespace()+"/"+patch.GetName())
+		if obj.GetKind() == "CustomResourceDefinition" {
+			unstructured.SetNestedSlice(obj.Object, []interface{}{map[string]interface{}{"type": "Established", "status": "True"}}, "status", "conditions")
+			fake.Tracker().Add(obj)
+		}
+		return true, obj, nil
+	})
+	orig := newClients
+	newClients = func(flags *genericclioptions.ConfigFlags) (*clients, error) {
+		if *flags.Context == "src" {
+			return source, nil
+		}
+		return target, nil
+	}
+	return func() { newClients = orig }
+}
+
+func TestMigrateCmd(t *testing.T) {
+	labeled := configMap("ns1", "labeled")
+	labeled.SetLabels(map[string]string{"app": "myapp"})
+	service := fixtureObject(t, "../test/fixtures/service1-raw.json")
+	service.SetNamespace("ns1")
+	objects := []runtime.Object{configMap("ns1", "a"), configMap("ns1", "b"), configMap("ns2", "c"), labeled, service}
+
+	testcases := []struct {
+		args       []string
+		namespaces string
+		all        bool
+		allNs      bool
+		selector   string
+		nsMap      []string
+		want       []string
+		wantErr    bool
+	}{
+		{
+			args:       []string{"cm/a", "configmaps/b"},
+			namespaces: "ns1",
+			want:       []string{"ConfigMap ns1/a", "ConfigMap ns1/b"},
+		},
+		{
+			args:       []string{"cm", "a", "b"},
+			namespaces: "ns1",
+			want:       []string{"ConfigMap ns1/a", "ConfigMap ns1/b"},
+		},
+		{
+			args:       []string{"cm,svc"},
+			namespaces: "ns1",
+			selector:   "app=myapp",
+			want:       []string{"ConfigMap ns1/labeled"},
+		},
+		{
+			args:  []string{"cm"},
+			allNs: true,
+			want:  []string{"ConfigMap ns1/a", "ConfigMap ns1/b", "ConfigMap ns1/labeled", "ConfigMap ns2/c"},
+		},
+		{
+			namespaces: "ns1",
+			all:        true,
+			want:       []string{"ConfigMap ns1/a", "ConfigMap ns1/b", "ConfigMap ns1/labeled", "Service ns1/myappservice"},
+		},
+		{
+			args:       []string{"cm"},
+			namespaces: "ns1,ns2",
+			nsMap:      []string{"ns1=ns1-prod"},
+			want:       []string{"ConfigMap ns1-prod/a", "ConfigMap ns1-prod/b", "ConfigMap ns1-prod/labeled", "ConfigMap ns2/c"},
+		},
+		{
+			args:       []string{"cm/a", "cm/missing"},
+			namespaces: "ns1",
+			want:       []string{"ConfigMap ns1/a"},
+			wantErr:    true,
+		},
+		{
+			args:    []string{},
+			wantErr: true,
+		},
+		{
+			args:    []string{"cm"},
+			all:     true,
+			wantErr: true,
+		},
+	}
+
+	migrateCmd.Flags().Set("source-context", "src")
+	migrateCmd.Flags().Set("target-context", "dst")
+	origNs := *kubeConfigFlags.Namespace
+	defer func() {
+		*kubeConfigFlags.Namespace, *migrateAll, *migrateAllNamespaces, labelSelector = origNs, false, false, ""
+		namespaceMapPairs = nil
+	}()
+	for _, tc := range testcases {
+		var applied []string
+		restore := fakeMigration(&applied, objects)
+		*kubeConfigFlags.Namespace, *migrateAll, *migrateAllNamespaces, labelSelector = tc.namespaces, tc.all, tc.allNs, tc.selector
+		namespaceMapPairs = tc.nsMap
+		cmdout := new(bytes.Buffer)
+		rootCmd.SetOut(cmdout)
+		rootCmd.SetErr(cmdout)
+		err := migrateCmd.RunE(migrateCmd, tc.args)
+		restore()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("test case '%v' failed. error: %v", tc.args, err)
+		}
+		if !reflect.DeepEqual(applied, tc.want) {
+			t.Errorf("test case '%v' failed. want: '%v' have: '%v'\noutput: %s", tc.args, tc.want, applied, cmdout.String())
+		}
+	}
+	rootCmd.SetOut(os.Stdout)
+	rootCmd.SetErr(os.Stderr)
+}
+
+func TestMigrateOrder(t *testing.T) {
+	crd := &unstructured.Unstructured{}
+	crd.UnmarshalJSON([]byte(`{"apiVersion": "apiextensions.k8s.io/v1", "kind": "CustomResourceDefinition", "metadata": {"name": "widgets.example.com"},
+		"spec": {"group": "example.com", "scope": "Namespaced", "names": {"kind": "Widget", "plural": "widgets"}, "versions": [{"name": "v1", "served": true, "storage": true}]}}`))
+	widget := &unstructured.Unstructured{}
+	widget.UnmarshalJSON([]byte(`{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w", "namespace": "ns1"}}`))
+	deploy := &unstructured.Unstructured{}
+	deploy.UnmarshalJSON([]byte(`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "ns1"},
+		"spec": {"template": {"spec": {"containers": [{"name": "web", "envFrom": [{"configMapRef": {"name": "b"}}]}]}}}}`))
+	ns := &unstructured.Unstructured{}
+	ns.UnmarshalJSON([]byte(`{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "ns1"}}`))
+
+	var applied []string
+	defer fakeMigration(&applied, []runtime.Object{crd, widget, deploy, ns, configMap("ns1", "b")})()
+	migrateCmd.Flags().Set("source-context", "src")
+	migrateCmd.Flags().Set("target-context", "dst")
+	origNs := *kubeConfigFlags.Namespace
+	defer func() { *kubeConfigFlags.Namespace = origNs }()
+	*kubeConfigFlags.Namespace = "ns1"
+	cmdout := new(bytes.Buffer)
+	rootCmd.SetOut(cmdout)
+	rootCmd.SetErr(cmdout)
+	defer rootCmd.SetOut(os.Stdout)
+	defer rootCmd.SetErr(os.Stderr)
+
+	err := migrateCmd.RunE(migrateCmd, []string{"widgets.example.com/w", "deploy/web", "cm/b", "ns/ns1", "crd/widgets.example.com"})
+	if err != nil {
+		t.Fatalf("error migrating: %v\noutput: %s", err, cmdout.String())
+	}
+	want := []string{
+		"Namespace /ns1",
+		"CustomResourceDefinition /widgets.example.com",
+		"ConfigMap ns1/b",
+		"Deployment ns1/web",
+		"Widget ns1/w",
+	}
+	if !reflect.DeepEqual(applied, want) {
+		t.Errorf("want: '%v' have: '%v'", want, applied)
+	}
+}
+
+func TestMigrateDryRunAndDiff(t *testing.T) {
+	changed := configMap("ns1", "a")
+	unstructured.SetNestedField(changed.Object, "old", "data", "name")
+	source := []runtime.Object{configMap("ns1", "a"), configMap("ns1", "b"), configMap("ns1", "c")}
+	target := []runtime.Object{changed, configMap("ns1", "b")}
+
+	testcases := []struct {
+		dryRun     string
+		diff       bool
+		wantApply  []string
+		wantErr    bool
+		wantOutput []string
+	}{
+		{
+			dryRun:     "client",
+			wantOutput: []string{"configmap/a serverside-applied (dry run)", "configmap/c serverside-applied (dry run)"},
+		},
+		{
+			dryRun:     "server",
+			wantApply:  []string{"ConfigMap ns1/a", "ConfigMap ns1/b", "ConfigMap ns1/c"},
+			wantOutput: []string{"configmap/b serverside-applied (server dry run)"},
+		},
+		{
+			dryRun:  "yes",
+			wantErr: true,
+		},
+		{
+			dryRun:  "none",
+			diff:    true,
+			wantErr: true,
+			wantOutput: []string{
+				"--- target/ns1/configmap/a\n+++ source/ns1/configmap/a\n",
+				" data:\n-  name: old\n+  name: a\n",
+				"--- target/ns1/configmap/c\n+++ source/ns1/configmap/c\n@@ -0,0 +1,7 @@\n+apiVersion: v1\n",
+			},
+		},
+		{
+			dryRun:  "server",
+			diff:    true,
+			wantErr: true,
+		},
+	}
+
+	migrateCmd.Flags().Set("source-context", "src")
+	migrateCmd.Flags().Set("target-context", "dst")
+	origNs := *kubeConfigFlags.Namespace
+	defer func() {
+		*kubeConfigFlags.Namespace, *migrateDryRun, *migrateDiff = origNs, "none", false
+		rootCmd.SetOut(os.Stdout)
+		rootCmd.SetErr(os.Stderr)
+	}()
+	for _, tc := range testcases {
+		var applied []string
+		restore := fakeMigration(&applied, source, target...)
+		*kubeConfigFlags.Namespace, *migrateDryRun, *migrateDiff = "ns1", tc.dryRun, tc.diff
+		cmdout := new(bytes.Buffer)
+		rootCmd.SetOut(cmdout)
+		rootCmd.SetErr(cmdout)
+		err := migrateCmd.RunE(migrateCmd, []string{"cm"})
+		restore()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("test case '%s' failed. error: %v", tc.dryRun, err)
+		}
+		if !reflect.DeepEqual(applied, tc.wantApply) {
+			t.Errorf("test case '%s' failed. want: '%v' have: '%v'", tc.dryRun, tc.wantApply, applied)
+		}
+		for _, out := range tc.wantOutput {
+			if !strings.Contains(cmdout.String(), out) {
+				t.Errorf("test case '%s' failed. want output: '%s' have: '%s'", tc.dryRun, out, cmdout.String())
+			}
+		}
+		if tc.diff && strings.Contains(cmdout.String(), "configmap/b") {
+			t.Errorf("test case '%s' failed. unchanged object in the diff: '%s'", tc.dryRun, cmdout.String())
+		}
+	}
+}
+
+func TestMigrateDiffUnknownKind(t *testing.T) {
+	target := fakeCluster()
+	obj := object("example.com/v1", "Widget", "ns1", "w")
+	if d, err := target.diff(context.Background(), obj); err == nil {
+		t.Errorf("want an error for a kind the target doesn't know, have diff: '%s'", d)
+	}
+}
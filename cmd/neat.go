@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/Baiyuani/kubectl-neatx/pkg/defaults"
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
@@ -21,18 +23,10 @@ func Neat(in string) (string, error) {
 		return draft, fmt.Errorf("error in neatPod, input is not a vaild json: %s", in[:20])
 	}
 
-	// handle list
-	if kind == "List" {
-		items := gjson.Get(draft, "items").Array()
-		for i, item := range items {
-			itemNeat, err := Neat(item.String())
-			if err != nil {
-				continue
-			}
-			draft, err = sjson.SetRaw(draft, fmt.Sprintf("items.%d", i), itemNeat)
-			if err != nil {
-				continue
-			}
+	if isList(draft) {
+		draft, err = neatList(draft)
+		if err != nil {
+			return draft, err
 		}
 		// general neating
 		draft, err = neatMetadata(draft, kind)
@@ -43,28 +37,18 @@ func Neat(in string) (string, error) {
 	}
 
 	// defaults neating
-	// draft, err = defaults.NeatDefaults(draft)
-	// if err != nil {
-	// 	return draft, fmt.Errorf("error in neatDefaults : %v", err)
-	// }
-
-	draft, err = neatSpec(draft, kind)
-	if err != nil {
-		return draft, fmt.Errorf("error in neatSpec : %v", err)
-	}
-
-	// controllers neating
-	// draft, err = neatScheduler(draft)
-	// if err != nil {
-	// 	return draft, fmt.Errorf("error in neatScheduler : %v", err)
-	// }
-	if kind == "Pod" {
-		draft, err = neatServiceAccount(draft)
+	if stripDefaults {
+		draft, err = defaults.NeatDefaults(draft)
 		if err != nil {
-			return draft, fmt.Errorf("error in neatServiceAccount : %v", err)
+			return draft, fmt.Errorf("error in neatDefaults : %v", err)
 		}
 	}
 
+	draft, err = neatRules(draft)
+	if err != nil {
+		return draft, fmt.Errorf("error in neatRules : %v", err)
+	}
+
 	// general neating
 	draft, err = neatMetadata(draft, kind)
 	if err != nil {
@@ -74,6 +58,14 @@ func Neat(in string) (string, error) {
 	if err != nil {
 		return draft, fmt.Errorf("error in neatStatus : %v", err)
 	}
+	draft, keep, err := secretPolicy.Apply(draft)
+	if err != nil {
+		return draft, fmt.Errorf("error in secrets policy : %v", err)
+	}
+	if !keep {
+		// the object is omitted
+		return "", nil
+	}
 	// draft, err = neatEmpty(draft)
 	// if err != nil {
 	// 	return draft, fmt.Errorf("error in neatEmpty : %v", err)
@@ -82,96 +74,81 @@ func Neat(in string) (string, error) {
 	return draft, nil
 }
 
-func neatSpec(in string, kind string) (string, error) {
-	var draft string
-	// var err   error
-
-	draft = in
+// isList tells if the object is a List, or a typed list such as PodList: a kind ending in List, with an items array
+func isList(in string) bool {
+	return strings.HasSuffix(gjson.Get(in, "kind").String(), "List") && gjson.Get(in, "items").IsArray()
+}
 
-	draft, _ = sjson.Delete(draft, "spec.template.metadata.creationTimestamp")
-	// if err != nil {
-	// 	return draft, fmt.Errorf("error deleting spec.template.metadata.creationTimestamp : %v", err)
-	// }
-	if kind == "Service" {
-		draft, _ = sjson.Delete(draft, "spec.clusterIP")
-		draft, _ = sjson.Delete(draft, "spec.clusterIPs")
-		// if err != nil {
-		// 	return draft, fmt.Errorf("error deleting spec.clusterIP : %v", err)
-		// }
-	}
-	if kind == "PersistentVolume" {
-		draft, _ = sjson.Delete(draft, "spec.claimRef")
+// neatList neats the items of a list, which may be lists themselves. the items of typed lists usually come without
+// kind and apiVersion, they're taken from the list so the items can be neated as the objects they are.
+// an item that can't be neated is reported and left out, the others are kept
+func neatList(in string) (string, error) {
+	kind := gjson.Get(in, "kind").String()
+	apiVersion := gjson.Get(in, "apiVersion").String()
+	items := gjson.Get(in, "items").Array()
+	if len(items) == 0 {
+		return in, nil
+	}
+	kept := []string{}
+	for i, item := range items {
+		if !item.IsObject() {
+			log.Warnf("leaving out item %d of the %s : not an object", i, kind)
+			continue
+		}
+		raw := item.Raw
+		var err error
+		if kind != "List" && !item.Get("kind").Exists() {
+			raw, err = sjson.Set(raw, "kind", strings.TrimSuffix(kind, "List"))
+			if err == nil && apiVersion != "" {
+				raw, err = sjson.Set(raw, "apiVersion", apiVersion)
+			}
+			if err != nil {
+				return in, fmt.Errorf("error setting the kind of item %d : %v", i, err)
+			}
+		}
+		itemNeat, err := Neat(raw)
+		if err != nil {
+			log.Warnf("leaving out item %d (%s %s) of the %s, it can't be neated : %v", i, gjson.Get(raw, "kind").String(), itemName(item), kind, err)
+			continue
+		}
+		if itemNeat != "" {
+			kept = append(kept, itemNeat)
+		}
 	}
-	if kind == "PersistentVolumeClaim" {
-		draft, _ = sjson.Delete(draft, `metadata.annotations.pv\.kubernetes\.io/bound-by-controller`)
-		draft, _ = sjson.Delete(draft, `metadata.annotations.pv\.kubernetes\.io/bind-completed`)
+	out, err := sjson.SetRaw(in, "items", "["+strings.Join(kept, ",")+"]")
+	if err != nil {
+		return in, fmt.Errorf("error setting list items : %v", err)
 	}
-	if kind == "Deployment" {
-		draft, _ = sjson.Delete(draft, `spec.template.metadata.annotations.kubectl\.kubernetes\.io/restartedAt`)
+	return out, nil
+}
+
+// itemName names a list item as namespace/name, or name
+func itemName(item gjson.Result) string {
+	name := item.Get("metadata.name").String()
+	if ns := item.Get("metadata.namespace").String(); ns != "" {
+		return ns + "/" + name
 	}
+	return name
+}
 
-	return draft, nil
+// neatRules runs the active rule set, which holds all kind specific neating
+func neatRules(in string) (string, error) {
+	return activeRules.Apply(in)
 }
 
+// neatMetadata keeps the metadata fields of the active policy
 func neatMetadata(in string, kind string) (string, error) {
-	var err error
-
 	in, _ = sjson.Delete(in, `metadata.annotations.kubectl\.kubernetes\.io/last-applied-configuration`)
-	if kind == "Deployment" {
-		in, _ = sjson.Delete(in, `metadata.annotations.deployment\.kubernetes\.io/revision`)
-	}
 	// if err != nil {
 	// 	return in, fmt.Errorf("error deleting last-applied-configuration : %v", err)
 	// }
-	// TODO: prettify this. gjson's @pretty is ok but setRaw the pretty code gives unwanted result
-	newMeta := gjson.Get(in, "{metadata.name,metadata.namespace,metadata.labels,metadata.annotations}")
-	in, err = sjson.Set(in, "metadata", newMeta.Value())
-	if err != nil {
-		return in, fmt.Errorf("error setting new metadata : %v", err)
-	}
-	return in, nil
+	return metadataPolicy.Apply(in)
 }
 
 func neatStatus(in string) (string, error) {
 	return sjson.Delete(in, "status")
 }
 
-func neatScheduler(in string) (string, error) {
-	return sjson.Delete(in, "spec.nodeName")
-}
-
-func neatServiceAccount(in string) (string, error) {
-	// var err error
-	// keep an eye open on https://github.com/tidwall/sjson/issues/11
-	// when it's implemented, we can do:
-	// sjson.delete(in, "spec.volumes.#(name%default-token-*)")
-	// sjson.delete(in, "spec.containers.#.volumeMounts.#(name%default-token-*)")
-
-	// for vi, v := range gjson.Get(in, "spec.volumes").Array() {
-	// 	vname := v.Get("name").String()
-	// 	if strings.HasPrefix(vname, "default-token-") {
-	// 		in, err = sjson.Delete(in, fmt.Sprintf("spec.volumes.%d", vi))
-	// 		if err != nil {
-	// 			continue
-	// 		}
-	// 	}
-	// }
-	// for ci, c := range gjson.Get(in, "spec.containers").Array() {
-	// 	for vmi, vm := range c.Get("volumeMounts").Array() {
-	// 		vmname := vm.Get("name").String()
-	// 		if strings.HasPrefix(vmname, "default-token-") {
-	// 			in, err = sjson.Delete(in, fmt.Sprintf("spec.containers.%d.volumeMounts.%d", ci, vmi))
-	// 			if err != nil {
-	// 				continue
-	// 			}
-	// 		}
-	// 	}
-	// }
-	in, _ = sjson.Delete(in, "spec.serviceAccount") //Deprecated: Use serviceAccountName instead
-
-	return in, nil
-}
-
 // neatEmpty removes all zero length elements in the json
 func neatEmpty(in string) (string, error) {
 	var err error
@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"bytes"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/Baiyuani/kubectl-neatx/pkg/secrets"
 	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+	log "github.com/sirupsen/logrus"
 )
 
 func TestNeatMetadata(t *testing.T) {
@@ -127,9 +131,9 @@ func TestNeatScheduler(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		resJSON, err := neatScheduler(c.data)
+		resJSON, err := neatRules(c.data)
 		if err != nil {
-			t.Errorf("error in neatScheduler for case '%s': %v", c.title, err)
+			t.Errorf("error in neatRules for case '%s': %v", c.title, err)
 			continue
 		}
 		equal, err := testutil.JSONEqual(resJSON, c.expect)
@@ -240,9 +244,9 @@ func TestNeatServiceAccount(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		resJSON, err := neatServiceAccount(c.data)
+		resJSON, err := neatRules(c.data)
 		if err != nil {
-			t.Errorf("error in neatServiceAccount for case '%s': %v", c.title, err)
+			t.Errorf("error in neatRules for case '%s': %v", c.title, err)
 			continue
 		}
 		equal, err := testutil.JSONEqual(resJSON, c.expect)
@@ -308,6 +312,9 @@ func TestNeatEmpty(t *testing.T) {
 }
 
 func TestNeat(t *testing.T) {
+	// the fixtures are neated with defaults stripped
+	stripDefaults = true
+	defer func() { stripDefaults = false }()
 	testsDir := "../test/fixtures"
 	testFiles, err := ioutil.ReadDir(testsDir)
 	if err != nil {
@@ -338,8 +345,110 @@ func TestNeat(t *testing.T) {
 				continue
 			}
 			if !equal {
-				t.Errorf("test case failed: %s:\nhave %s\nwant %s", fName, string(expBytes), resJSON)
+				t.Errorf("test case failed: %s:\nwant %s\nhave %s", fName, string(expBytes), resJSON)
 			}
 		}
 	}
 }
+
+func TestNeatSecrets(t *testing.T) {
+	secret := `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "db", "namespace": "app1", "uid": "1"}, "type": "Opaque", "data": {"password": "czNjcjN0"}}`
+	cm := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "db", "namespace": "app1", "uid": "2"}, "data": {"password": "czNjcjN0"}}`
+	list := `{"apiVersion": "v1", "kind": "List", "metadata": {"resourceVersion": ""}, "items": [` + secret + `,` + cm + `]}`
+	neatCM := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "db", "namespace": "app1"}, "data": {"password": "czNjcjN0"}}`
+	cases := []struct {
+		title  string
+		mode   string
+		data   string
+		expect string
+	}{
+		{"keep", "keep", secret, `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "db", "namespace": "app1"}, "type": "Opaque", "data": {"password": "czNjcjN0"}}`},
+		{"redact", "redact", secret, `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "db", "namespace": "app1", "annotations": {"kubectl-neatx.io/secrets": "redacted"}}, "type": "Opaque", "data": {"password": "UkVEQUNURUQ="}}`},
+		{"omit", "omit", secret, ""},
+		{"omit in list", "omit", list, `{"apiVersion": "v1", "kind": "List", "metadata": {}, "items": [` + neatCM + `]}`},
+	}
+	defer func() { secretPolicy = nil }()
+	for _, c := range cases {
+		var err error
+		secretPolicy, err = secrets.NewPolicy(c.mode, nil)
+		if err != nil {
+			t.Fatalf("error building policy: %v", err)
+		}
+		resJSON, err := Neat(c.data)
+		if err != nil {
+			t.Errorf("error in Neat for case '%s': %v", c.title, err)
+			continue
+		}
+		if c.expect == "" {
+			if resJSON != "" {
+				t.Errorf("test case '%s' failed. want: '' have: '%s'", c.title, resJSON)
+			}
+			continue
+		}
+		equal, err := testutil.JSONEqual(resJSON, c.expect)
+		if err != nil || !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, resJSON)
+		}
+	}
+}
+
+func TestNeatList(t *testing.T) {
+	pod := `{"metadata": {"name": "p1", "namespace": "app1", "uid": "1", "resourceVersion": "2"}, "spec": {"containers": [{"name": "c", "image": "nginx"}]}, "status": {"phase": "Running"}}`
+	neatPod := `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "p1", "namespace": "app1"}, "spec": {"containers": [{"name": "c", "image": "nginx"}]}}`
+	cm := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c1", "uid": "3"}, "data": {"a": "b"}}`
+	neatCM := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c1"}, "data": {"a": "b"}}`
+	cases := []struct {
+		title  string
+		data   string
+		expect string
+	}{
+		{
+			title:  "typed list",
+			data:   `{"apiVersion": "v1", "kind": "PodList", "metadata": {"resourceVersion": "5"}, "items": [` + pod + `]}`,
+			expect: `{"apiVersion": "v1", "kind": "PodList", "metadata": {}, "items": [` + neatPod + `]}`,
+		},
+		{
+			title:  "nested lists",
+			data:   `{"apiVersion": "v1", "kind": "List", "items": [` + cm + `, {"apiVersion": "v1", "kind": "PodList", "items": [` + pod + `]}, {"apiVersion": "v1", "kind": "List", "items": [` + cm + `]}]}`,
+			expect: `{"apiVersion": "v1", "kind": "List", "items": [` + neatCM + `, {"apiVersion": "v1", "kind": "PodList", "items": [` + neatPod + `]}, {"apiVersion": "v1", "kind": "List", "items": [` + neatCM + `]}]}`,
+		},
+		{
+			title:  "kind ending in List without items",
+			data:   `{"apiVersion": "example.com/v1", "kind": "AllowList", "metadata": {"name": "a", "uid": "1"}, "spec": {"items": "x"}}`,
+			expect: `{"apiVersion": "example.com/v1", "kind": "AllowList", "metadata": {"name": "a"}, "spec": {"items": "x"}}`,
+		},
+	}
+	for _, c := range cases {
+		resJSON, err := Neat(c.data)
+		if err != nil {
+			t.Errorf("error in Neat for case '%s': %v", c.title, err)
+			continue
+		}
+		equal, err := testutil.JSONEqual(resJSON, c.expect)
+		if err != nil || !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, resJSON)
+		}
+	}
+
+	// items that can't be neated are reported and left out, the others are kept
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	secretPolicy = &secrets.Policy{Mode: secrets.Redact}
+	defer func() { secretPolicy = nil }()
+	bad := `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "bad", "namespace": "app1"}, "data": {"a": "not base64"}}`
+	resJSON, err := Neat(`{"apiVersion": "v1", "kind": "List", "items": [` + cm + `, ` + bad + `, {"apiVersion": "v1", "kind": "List", "items": ["", ` + cm + `]}]}`)
+	if err != nil {
+		t.Fatalf("error in Neat: %v", err)
+	}
+	expect := `{"apiVersion": "v1", "kind": "List", "items": [` + neatCM + `, {"apiVersion": "v1", "kind": "List", "items": [` + neatCM + `]}]}`
+	equal, err := testutil.JSONEqual(resJSON, expect)
+	if err != nil || !equal {
+		t.Errorf("want: '%s' have: '%s'", expect, resJSON)
+	}
+	for _, want := range []string{"item 1 (Secret app1/bad) of the List", "item 0 of the List : not an object"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("want a warning about '%s', have: %s", want, logs.String())
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	s "strings"
+	"sync"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/resources"
+	"github.com/tidwall/gjson"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// exportJob is one list call of the export pipeline: a resource in a namespace, or cluster wide
+type exportJob struct {
+	// arg is the resource as the user typed it
+	arg       string
+	resource  resources.Resource
+	namespace string
+	// name makes the job get a single object instead of listing
+	name string
+	// listOptions carry the label and field selectors of the list call
+	listOptions metav1.ListOptions
+}
+
+// exportResult is an object listed and neated by the export pipeline, or the error of a job or an object
+type exportResult struct {
+	job   exportJob
+	index int
+	// namespace and name of the object, set for cluster wide lists too
+	namespace string
+	name      string
+	// json is the neated object
+	json []byte
+	// report lists the rewrites transformObject made
+	report []string
+	// ref is the object as it was listed, before any namespace mapping
+	ref objectRef
+	// controller is the controller of the object, if it has one
+	controller *objectRef
+	// owner is the controller of an object that was skipped because of it, as Kind/name
+	owner string
+	err   error
+}
+
+// errorString describes the error of a result
+func (res exportResult) errorString() string {
+	where := ""
+	if res.job.namespace != "" {
+		where = " in namespace " + res.job.namespace
+	}
+	if res.name == "" {
+		return fmt.Sprintf("error listing %s%s : %v", res.job.resource, where, res.err)
+	}
+	return fmt.Sprintf("error exporting %s/%s%s : %v", res.job.resource, res.name, where, res.err)
+}
+
+// transformObject runs the rewrites asked for by flags on a neated object, and reports the ones worth telling.
+// 'original' is the object before neating
+func transformObject(original, in []byte) ([]byte, []string, error) {
+	// before the namespace map, so it moves the claimRef of volumes too
+	out, err := storageMap.Apply(string(in), string(original))
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err = namespaceMap.Apply(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	var report []string
+	out, images, err := imageMap.Apply(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range images {
+		report = append(report, "image rewritten: "+r.String())
+	}
+	return []byte(out), report, nil
+}
+
+// objectRef identifies an object of an export
+type objectRef struct {
+	group     string
+	kind      string
+	namespace string
+	name      string
+}
+
+func (r objectRef) String() string {
+	return r.kind + "/" + r.name
+}
+
+// refOf returns the reference of 'obj'
+func refOf(obj *unstructured.Unstructured) objectRef {
+	gvk := obj.GroupVersionKind()
+	return objectRef{group: gvk.Group, kind: gvk.Kind, namespace: obj.GetNamespace(), name: obj.GetName()}
+}
+
+// controllerRef returns the reference of the controller of 'obj', which lives in the namespace of 'obj'
+func controllerRef(obj *unstructured.Unstructured) *objectRef {
+	ref := metav1.GetControllerOf(obj)
+	if ref == nil {
+		return nil
+	}
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil
+	}
+	return &objectRef{group: gv.Group, kind: ref.Kind, namespace: obj.GetNamespace(), name: ref.Name}
+}
+
+// skipOwned marks the results whose controller is exported too: the controller recreates them,
+// so exporting both would make duplicates
+func skipOwned(results []exportResult) {
+	exported := map[objectRef]bool{}
+	for _, res := range results {
+		if res.err == nil && res.ref.kind != "" {
+			exported[res.ref] = true
+		}
+	}
+	for i := range results {
+		c := results[i].controller
+		if c == nil {
+			continue
+		}
+		// controllers of namespaced objects may be cluster scoped
+		clusterScoped := *c
+		clusterScoped.namespace = ""
+		if exported[*c] || exported[clusterScoped] {
+			results[i].owner = c.String()
+			results[i].json, results[i].report, results[i].err = nil, nil, nil
+		}
+	}
+}
+
+// selectors are the list options of --selector and --field-selector, the server filters the objects with them
+func selectors() metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: labelSelector, FieldSelector: fieldSelector}
+}
+
+// exportJobs resolves the targets and builds their jobs: cluster scoped resources once, namespaced ones in each of 'namespaces'
+func exportJobs(c *clients, targets []getTarget, namespaces []string, listOptions metav1.ListOptions) ([]exportJob, error) {
+	var clusterJobs, namespacedJobs []exportJob
+	for _, t := range targets {
+		r, err := c.resourceFor(t.resource)
+		if err != nil {
+			return nil, err
+		}
+		job := exportJob{arg: t.resource, resource: r, name: t.name, listOptions: listOptions}
+		if !r.Namespaced {
+			clusterJobs = append(clusterJobs, job)
+		} else {
+			namespacedJobs = append(namespacedJobs, job)
+		}
+	}
+	jobs := clusterJobs
+	for _, ns := range namespaces {
+		for _, job := range namespacedJobs {
+			job.namespace = ns
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// splitKinds flattens repeated comma separated kind flags
+func splitKinds(values []string) []string {
+	var res []string
+	for _, v := range values {
+		for _, k := range s.Split(v, ",") {
+			if k != "" {
+				res = append(res, k)
+			}
+		}
+	}
+	return res
+}
+
+// ephemeralResources are left out of whole namespace and cluster exports by default: they're recreated by the cluster,
+// describe its current state or can't be read back
+var ephemeralResources = []string{
+	"events",
+	"events.events.k8s.io",
+	"endpoints",
+	"endpointslices.discovery.k8s.io",
+	"leases.coordination.k8s.io",
+	"controllerrevisions.apps",
+	"bindings",
+	"componentstatuses",
+	"nodes",
+	"csinodes.storage.k8s.io",
+	"volumeattachments.storage.k8s.io",
+	"csistoragecapacities.storage.k8s.io",
+	"certificatesigningrequests.certificates.k8s.io",
+	"tokenreviews.authentication.k8s.io",
+	"selfsubjectreviews.authentication.k8s.io",
+	"localsubjectaccessreviews.authorization.k8s.io",
+	"selfsubjectaccessreviews.authorization.k8s.io",
+	"selfsubjectrulesreviews.authorization.k8s.io",
+	"subjectaccessreviews.authorization.k8s.io",
+	"pods.metrics.k8s.io",
+	"nodes.metrics.k8s.io",
+}
+
+// exportableTargets are all the resources of a scope that can be listed and read back, in their preferred version.
+// the ephemeral resources and the 'exclude' ones are left out, unless they're in 'include'
+func exportableTargets(c *clients, namespaced bool, include []string, exclude []string) ([]getTarget, error) {
+	excluded := map[schema.GroupResource]bool{}
+	for _, e := range ephemeralResources {
+		excluded[schema.ParseGroupResource(e)] = true
+	}
+	for _, e := range exclude {
+		r, err := c.resourceFor(e)
+		if err != nil {
+			return nil, err
+		}
+		excluded[r.GroupResource()] = true
+	}
+	for _, i := range include {
+		r, err := c.resourceFor(i)
+		if err != nil {
+			return nil, err
+		}
+		excluded[r.GroupResource()] = false
+	}
+	var res []getTarget
+	for _, r := range c.resolver.Preferred() {
+		if r.Namespaced == namespaced && r.HasVerbs("list", "get") && !excluded[r.GroupResource()] {
+			res = append(res, getTarget{resource: r.String()})
+		}
+	}
+	return res, nil
+}
+
+// jobItems runs the list call of a job, or its get call when it's about a single object
+func (c *clients) jobItems(ctx context.Context, job exportJob) ([]unstructured.Unstructured, error) {
+	if job.name == "" {
+		return c.listItems(ctx, job.resource, job.namespace, job.listOptions)
+	}
+	data, err := c.getJSON(ctx, job.resource, job.namespace, job.name)
+	if err != nil {
+		return nil, err
+	}
+	var obj unstructured.Unstructured
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return []unstructured.Unstructured{obj}, nil
+}
+
+// runExport lists every job once and neats the listed objects, with at most 'concurrency' list calls
+// and as many neat workers in flight. the results come sorted by job then name, whatever the scheduling
+func runExport(ctx context.Context, c *clients, jobs []exportJob, concurrency int) []exportResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	type listed struct {
+		index int
+		obj   unstructured.Unstructured
+	}
+	jobCh := make(chan int)
+	itemCh := make(chan listed)
+	resCh := make(chan exportResult)
+
+	var listers, neaters sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		listers.Add(1)
+		go func() {
+			defer listers.Done()
+			for index := range jobCh {
+				items, err := c.jobItems(ctx, jobs[index])
+				if err != nil {
+					resCh <- exportResult{job: jobs[index], index: index, name: jobs[index].name, err: err}
+					continue
+				}
+				for _, obj := range items {
+					itemCh <- listed{index: index, obj: obj}
+				}
+			}
+		}()
+		neaters.Add(1)
+		go func() {
+			defer neaters.Done()
+			for item := range itemCh {
+				res := exportResult{job: jobs[item.index], index: item.index, namespace: item.obj.GetNamespace(), name: item.obj.GetName(),
+					ref: refOf(&item.obj), controller: controllerRef(&item.obj)}
+				data, err := item.obj.MarshalJSON()
+				if err == nil {
+					res.json, err = NeatYAMLOrJSON(data, "json")
+				}
+				if err == nil && res.json == nil {
+					// omitted by the secrets policy
+					continue
+				}
+				if err == nil {
+					res.json, res.report, err = transformObject(data, res.json)
+					// the object may have moved to another namespace
+					res.namespace = gjson.GetBytes(res.json, "metadata.namespace").String()
+				}
+				res.err = err
+				resCh <- res
+			}
+		}()
+	}
+	go func() {
+		for index := range jobs {
+			jobCh <- index
+		}
+		close(jobCh)
+		listers.Wait()
+		close(itemCh)
+		neaters.Wait()
+		close(resCh)
+	}()
+
+	var results []exportResult
+	for res := range resCh {
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].index != results[j].index {
+			return results[i].index < results[j].index
+		}
+		if results[i].namespace != results[j].namespace {
+			return results[i].namespace < results[j].namespace
+		}
+		return results[i].name < results[j].name
+	})
+	if !includeOwned {
+		skipOwned(results)
+	}
+	return results
+}
@@ -0,0 +1,226 @@
+package defaults
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/paths"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/sjson"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/defaulting"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// crdSchemas holds the structural schemas of the registered custom resources, by GVK
+var crdSchemas = struct {
+	sync.RWMutex
+	m map[schema.GroupVersionKind]*structuralschema.Structural
+}{m: map[schema.GroupVersionKind]*structuralschema.Structural{}}
+
+// AddCRD registers the OpenAPI v3 schemas of all versions of a CRD, so NeatDefaults can strip their `default:` values
+func AddCRD(crd *apiextensionsv1.CustomResourceDefinition) error {
+	for _, v := range crd.Spec.Versions {
+		if v.Schema == nil || v.Schema.OpenAPIV3Schema == nil {
+			continue
+		}
+		var internal apiextensions.JSONSchemaProps
+		err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(v.Schema.OpenAPIV3Schema, &internal, nil)
+		if err != nil {
+			return fmt.Errorf("error converting schema of %s/%s : %v", crd.Name, v.Name, err)
+		}
+		ss, err := structuralschema.NewStructural(&internal)
+		if err != nil {
+			return fmt.Errorf("error building structural schema of %s/%s : %v", crd.Name, v.Name, err)
+		}
+		gvk := schema.GroupVersionKind{Group: crd.Spec.Group, Version: v.Name, Kind: crd.Spec.Names.Kind}
+		crdSchemas.Lock()
+		crdSchemas.m[gvk] = ss
+		crdSchemas.Unlock()
+	}
+	return nil
+}
+
+// LoadCRDs registers every CustomResourceDefinition found in a YAML or JSON stream,
+// either as separate documents or as the items of a List
+func LoadCRDs(r io.Reader) error {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var doc map[string]interface{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error decoding CRD document : %v", err)
+		}
+		docs := []interface{}{doc}
+		if items, ok := doc["items"].([]interface{}); ok {
+			docs = items
+		}
+		for _, d := range docs {
+			if err := loadCRD(d); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// LoadCRDFile registers every CustomResourceDefinition found in a local YAML or JSON file
+func LoadCRDFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := LoadCRDs(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("error loading CRDs from %s : %v", path, err)
+	}
+	return nil
+}
+
+func loadCRD(doc interface{}) error {
+	m, ok := doc.(map[string]interface{})
+	if !ok || m["kind"] != "CustomResourceDefinition" {
+		return nil
+	}
+	if m["apiVersion"] != apiextensionsv1.SchemeGroupVersion.String() {
+		log.Warnf("skipping CRD %v, only %s is supported", m["metadata"], apiextensionsv1.SchemeGroupVersion)
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var crd apiextensionsv1.CustomResourceDefinition
+	if err := json.Unmarshal(data, &crd); err != nil {
+		return fmt.Errorf("error unmarshaling CRD : %v", err)
+	}
+	return AddCRD(&crd)
+}
+
+func crdSchemaFor(gvk schema.GroupVersionKind) *structuralschema.Structural {
+	crdSchemas.RLock()
+	defer crdSchemas.RUnlock()
+	return crdSchemas.m[gvk]
+}
+
+// neatCRDDefaults removes the fields of a custom resource whose value is the `default:` of its structural schema.
+// fields are only removed if running the structural defaulting on the result gives back the same object.
+func neatCRDDefaults(in string, ss *structuralschema.Structural) (string, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(in), &obj); err != nil {
+		return "", fmt.Errorf("error unmarshaling custom resource : %v", err)
+	}
+	var candidates [][]string
+	for k, v := range obj {
+		if skippedTopLevel[k] {
+			continue
+		}
+		if prop, ok := ss.Properties[k]; ok {
+			findSchemaDefaults(v, &prop, []string{k}, &candidates)
+		}
+	}
+	if len(candidates) == 0 {
+		return in, nil
+	}
+
+	want := defaulted(obj, ss)
+	if defaulted(without(obj, candidates), ss) != want {
+		// the candidates interfere with each other, settle for the ones that are safe on their own
+		var safe [][]string
+		for _, c := range candidates {
+			if defaulted(without(obj, [][]string{c}), ss) == want {
+				safe = append(safe, c)
+			}
+		}
+		candidates = safe
+	}
+
+	var err error
+	for _, c := range candidates {
+		path := paths.Join(c...)
+		in, err = sjson.Delete(in, path)
+		if err != nil {
+			log.Error(fmt.Errorf("error deleting default '%s' : %v", path, err))
+		}
+	}
+	return in, nil
+}
+
+// findSchemaDefaults collects the paths of values equal to their schema default.
+// values that aren't defaults are walked into, to find nested defaults.
+func findSchemaDefaults(v interface{}, s *structuralschema.Structural, path []string, res *[][]string) {
+	if s == nil {
+		return
+	}
+	if s.Default.Object != nil {
+		// the default itself gets defaulted, e.g. `default: {}` for an object with defaulted properties
+		effective := runtime.DeepCopyJSONValue(s.Default.Object)
+		defaulting.Default(effective, s)
+		if sameJSON(v, s.Default.Object) || sameJSON(v, effective) {
+			*res = append(*res, path)
+			return
+		}
+	}
+	findChildSchemaDefaults(v, s, path, res)
+}
+
+func findChildSchemaDefaults(v interface{}, s *structuralschema.Structural, path []string, res *[][]string) {
+	if s == nil {
+		return
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			childPath := append(append([]string{}, path...), k)
+			if prop, ok := s.Properties[k]; ok {
+				findSchemaDefaults(child, &prop, childPath, res)
+			} else if s.AdditionalProperties != nil {
+				findSchemaDefaults(child, s.AdditionalProperties.Structural, childPath, res)
+			}
+		}
+	case []interface{}:
+		// array items are positional, the defaults inside them can go but not the items themselves
+		for i, child := range v {
+			findChildSchemaDefaults(child, s.Items, append(append([]string{}, path...), strconv.Itoa(i)), res)
+		}
+	}
+}
+
+// defaulted runs the structural defaulting on a copy of 'obj' and returns it as canonical json
+func defaulted(obj map[string]interface{}, ss *structuralschema.Structural) string {
+	cp := runtime.DeepCopyJSONValue(obj)
+	defaulting.Default(cp, ss)
+	res, _ := json.Marshal(cp)
+	return string(res)
+}
+
+// without returns a copy of 'obj' with the fields at 'paths' removed
+func without(obj map[string]interface{}, paths [][]string) map[string]interface{} {
+	cp := runtime.DeepCopyJSONValue(obj).(map[string]interface{})
+	for _, p := range paths {
+		var cur interface{} = cp
+		for _, k := range p[:len(p)-1] {
+			switch c := cur.(type) {
+			case map[string]interface{}:
+				cur = c[k]
+			case []interface{}:
+				i, _ := strconv.Atoi(k)
+				cur = c[i]
+			}
+		}
+		if m, ok := cur.(map[string]interface{}); ok {
+			delete(m, p[len(p)-1])
+		}
+	}
+	return cp
+}
@@ -0,0 +1,144 @@
+package defaults
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+)
+
+const testCRD = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              replicas:
+                type: integer
+                default: 1
+              mode:
+                type: string
+                default: auto
+              size:
+                type: string
+              tls:
+                type: object
+                default: {}
+                properties:
+                  enabled:
+                    type: boolean
+                    default: true
+              ports:
+                type: array
+                items:
+                  type: object
+                  properties:
+                    port:
+                      type: integer
+                    protocol:
+                      type: string
+                      default: TCP
+`
+
+func TestNeatCRDDefaults(t *testing.T) {
+	if err := LoadCRDs(strings.NewReader(testCRD)); err != nil {
+		t.Fatalf("error loading test CRD: %v", err)
+	}
+	cases := []struct {
+		title  string
+		data   string
+		expect string
+	}{
+		{
+			title: "top level defaults",
+			data: `{
+				"apiVersion": "example.com/v1",
+				"kind": "Widget",
+				"metadata": {"name": "w", "namespace": "default"},
+				"spec": {"replicas": 1, "mode": "manual", "size": "large"}
+			}`,
+			expect: `{
+				"apiVersion": "example.com/v1",
+				"kind": "Widget",
+				"metadata": {"name": "w", "namespace": "default"},
+				"spec": {"mode": "manual", "size": "large"}
+			}`,
+		},
+		{
+			title: "nested object default",
+			data: `{
+				"apiVersion": "example.com/v1",
+				"kind": "Widget",
+				"metadata": {"name": "w", "namespace": "default"},
+				"spec": {"size": "large", "tls": {"enabled": true}}
+			}`,
+			expect: `{
+				"apiVersion": "example.com/v1",
+				"kind": "Widget",
+				"metadata": {"name": "w", "namespace": "default"},
+				"spec": {"size": "large"}
+			}`,
+		},
+		{
+			title: "array items",
+			data: `{
+				"apiVersion": "example.com/v1",
+				"kind": "Widget",
+				"metadata": {"name": "w", "namespace": "default"},
+				"spec": {"ports": [{"port": 80, "protocol": "TCP"}, {"port": 53, "protocol": "UDP"}]}
+			}`,
+			expect: `{
+				"apiVersion": "example.com/v1",
+				"kind": "Widget",
+				"metadata": {"name": "w", "namespace": "default"},
+				"spec": {"ports": [{"port": 80}, {"port": 53, "protocol": "UDP"}]}
+			}`,
+		},
+		{
+			title: "unknown version",
+			data: `{
+				"apiVersion": "example.com/v2",
+				"kind": "Widget",
+				"metadata": {"name": "w", "namespace": "default"},
+				"spec": {"replicas": 1}
+			}`,
+			expect: `{
+				"apiVersion": "example.com/v2",
+				"kind": "Widget",
+				"metadata": {"name": "w", "namespace": "default"},
+				"spec": {"replicas": 1}
+			}`,
+		},
+	}
+	for _, c := range cases {
+		resJSON, err := NeatDefaults(c.data)
+		if err != nil {
+			t.Errorf("error in NeatDefaults for case '%s': %v", c.title, err)
+			continue
+		}
+		equal, err := testutil.JSONEqual(resJSON, c.expect)
+		if err != nil {
+			t.Errorf("error in JSONEqual for case '%s': %v", c.title, err)
+			continue
+		}
+		if !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, resJSON)
+		}
+	}
+}
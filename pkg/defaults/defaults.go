@@ -3,19 +3,36 @@ package defaults
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 
-	"github.com/jeremywohl/flatten"
+	"github.com/Baiyuani/kubectl-neatx/pkg/paths"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
+	admissionregistrationv1 "k8s.io/kubernetes/pkg/apis/admissionregistration/v1"
+	appsv1 "k8s.io/kubernetes/pkg/apis/apps/v1"
+	autoscalingv1 "k8s.io/kubernetes/pkg/apis/autoscaling/v1"
+	autoscalingv2 "k8s.io/kubernetes/pkg/apis/autoscaling/v2"
+	batchv1 "k8s.io/kubernetes/pkg/apis/batch/v1"
+	certificatesv1 "k8s.io/kubernetes/pkg/apis/certificates/v1"
+	coordinationv1 "k8s.io/kubernetes/pkg/apis/coordination/v1"
 	apisv1 "k8s.io/kubernetes/pkg/apis/core/v1"
+	discoveryv1 "k8s.io/kubernetes/pkg/apis/discovery/v1"
+	networkingv1 "k8s.io/kubernetes/pkg/apis/networking/v1"
+	policyv1 "k8s.io/kubernetes/pkg/apis/policy/v1"
+	rbacv1 "k8s.io/kubernetes/pkg/apis/rbac/v1"
+	schedulingv1 "k8s.io/kubernetes/pkg/apis/scheduling/v1"
+	storagev1 "k8s.io/kubernetes/pkg/apis/storage/v1"
 )
 
 // NeatDefaults gets a json document representing a Kubernetes resource, and removes all fields with default values.
-// default values is determined by invoking the "defaulting" code from Kubernetes apimachinery
+// default values is determined by invoking the "defaulting" code from Kubernetes apimachinery,
+// or for custom resources by the `default:` values of CRD schemas registered with AddCRD or LoadCRDs
 func NeatDefaults(in string) (string, error) {
 	var err error
 
@@ -25,90 +42,207 @@ func NeatDefaults(in string) (string, error) {
 		return "", fmt.Errorf("error unmarshaling as PartialObject : %v", err)
 	}
 	if !myscheme.Recognizes(pom.GroupVersionKind()) {
+		if ss := crdSchemaFor(pom.GroupVersionKind()); ss != nil {
+			return neatCRDDefaults(in, ss)
+		}
 		return in, nil
 	}
 
-	specJSON := gjson.Get(in, "spec")
-	if !specJSON.Exists() {
-		return in, nil
-	}
-	pathsToDelete, err := flatMapJSON(specJSON.String(), "spec.")
+	obj, _, err := decoder.Decode([]byte(in), nil, nil)
 	if err != nil {
-		return "", fmt.Errorf("error flattening json : %v", err)
+		return "", fmt.Errorf("error decoding into kubernetes object : %v", err)
 	}
-	for k, v := range pathsToDelete {
-		isDefault, err := isDefault(k, v, in)
+	for _, path := range findDefaults(obj) {
+		in, err = sjson.Delete(in, path)
 		if err != nil {
-			log.Error(fmt.Errorf("error determining default for '%s' : %v", k, err))
+			log.Error(fmt.Errorf("error deleting default '%s' : %v", path, err))
 			continue
 		}
-		if !isDefault {
-			// don't want to delete from 'in' yet because that would affect the following isDefault tests
-			delete(pathsToDelete, k)
+		in = deleteEmptiedParents(in, path)
+	}
+	return deleteEmptyDefaults(in)
+}
+
+// deleteEmptyDefaults removes the empty objects that carry no information: the ones defaulting brings back,
+// e.g. a pod securityContext: {}, and the optional ones that are always serialized, e.g. a container resources: {}.
+// empty objects that are required, e.g. the podSelector: {} of a NetworkPolicy, stay
+func deleteEmptyDefaults(in string) (string, error) {
+	var empties [][]string
+	gjson.Parse(in).ForEach(func(k, v gjson.Result) bool {
+		if !skippedTopLevel[k.String()] {
+			findEmptyObjects(v, []string{k.String()}, &empties)
 		}
+		return true
+	})
+	if len(empties) == 0 {
+		return in, nil
 	}
-	for k := range pathsToDelete {
-		in, err = sjson.Delete(in, k)
+	candidate := in
+	var err error
+	for _, keys := range empties {
+		candidate, err = sjson.Delete(candidate, paths.Join(keys...))
 		if err != nil {
-			log.Error(fmt.Errorf("error deleting default '%s' : %v", k, err))
-			continue
+			return "", fmt.Errorf("error deleting '%s' : %v", paths.Join(keys...), err)
 		}
 	}
-	return in, nil
-}
-
-// flatMapJSON gets a json document and builds a map of all the leaf keys and their values
-func flatMapJSON(j string, prefix string) (map[string]interface{}, error) {
-	var jParsed map[string]interface{}
-	err := json.Unmarshal([]byte(j), &jParsed)
+	obj, _, err := decoder.Decode([]byte(candidate), nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error decoding into kubernetes object : %v", err)
+	}
+	undefaulted, err := json.Marshal(obj)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling: %v", err)
+		return "", fmt.Errorf("error marshaling kubernetes object : %v", err)
+	}
+	var redundant []string
+	for _, keys := range empties {
+		if gjson.GetBytes(undefaulted, paths.Join(keys...)).Exists() && omitsEmpty(reflect.ValueOf(obj), keys) {
+			redundant = append(redundant, paths.Join(keys...))
+		}
 	}
-	res, err := flatten.Flatten(jParsed, prefix, flatten.DotStyle)
+	myscheme.Default(obj)
+	defaulted, err := json.Marshal(obj)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("error marshaling kubernetes object : %v", err)
 	}
-	return res, nil
+	for _, keys := range empties {
+		path := paths.Join(keys...)
+		if gjson.GetBytes(undefaulted, path).Exists() {
+			continue
+		}
+		if v := gjson.GetBytes(defaulted, path); v.IsObject() && len(v.Map()) == 0 {
+			redundant = append(redundant, path)
+		}
+	}
+	for _, path := range redundant {
+		in, err = sjson.Delete(in, path)
+		if err != nil {
+			return "", fmt.Errorf("error deleting '%s' : %v", path, err)
+		}
+	}
+	return in, nil
 }
 
-var myscheme *runtime.Scheme
-var decoder runtime.Decoder
-
-func init() {
-	myscheme = runtime.NewScheme()
-	apisv1.AddToScheme(myscheme)
-	decoder = scheme.Codecs.UniversalDeserializer()
+// findEmptyObjects appends the keys to the empty objects under 'v' to 'res'. array elements are left alone,
+// removing one would shift its siblings
+func findEmptyObjects(v gjson.Result, keys []string, res *[][]string) {
+	switch {
+	case v.IsObject() && len(v.Map()) == 0:
+		*res = append(*res, keys)
+	case v.IsObject():
+		v.ForEach(func(k, child gjson.Result) bool {
+			findEmptyObjects(child, append(keys[:len(keys):len(keys)], k.String()), res)
+			return true
+		})
+	case v.IsArray():
+		for i, child := range v.Array() {
+			if child.IsObject() && len(child.Map()) > 0 {
+				findEmptyObjects(child, append(keys[:len(keys):len(keys)], strconv.Itoa(i)), res)
+			}
+		}
+	}
 }
 
-// isDefault determins if the observed 'value' of the 'path' (gjson path) to field  in 'objJSON' is a default value
-func isDefault(path string, value interface{}, objJSON string) (bool, error) {
-	computed, err := computeDefault(path, objJSON)
-	if err != nil {
-		return false, fmt.Errorf("error computing default for '%s' : %v", path, err)
+// omitsEmpty tells if the field on 'keys' of 'v' is an optional struct. those are serialized even when
+// empty, so an empty one is the same as a missing one
+func omitsEmpty(v reflect.Value, keys []string) bool {
+	tag := ""
+	for _, k := range keys {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return false
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			var ok bool
+			v, tag, ok = fieldByJSONName(v, k)
+			if !ok {
+				return false
+			}
+		case reflect.Slice:
+			i, err := strconv.Atoi(k)
+			if err != nil || i >= v.Len() {
+				return false
+			}
+			v, tag = v.Index(i), ""
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return false
+			}
+			v, tag = v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())), ""
+			if !v.IsValid() {
+				return false
+			}
+		default:
+			return false
+		}
 	}
-	expect := fmt.Sprintf("%v", value)
-	return computed == expect, nil
+	return v.Kind() == reflect.Struct && strings.Contains(tag, ",omitempty")
 }
 
-// computeDefault returns the default value for the 'path' (gjson path) to field in 'objJSON'
-func computeDefault(path string, objJSON string) (string, error) {
-	candidateJSON, err := sjson.Delete(objJSON, path)
-	if err != nil {
-		return "", fmt.Errorf("error deleting path to default '%s' : %v", path, err)
+// fieldByJSONName returns the field of the struct 'v' serialized as 'name', and its json tag
+func fieldByJSONName(v reflect.Value, name string) (reflect.Value, string, bool) {
+	var found []int
+	walkFields(v.Type(), nil, func(index []int, n string, _ reflect.Type) {
+		if n == name && found == nil {
+			found = index
+		}
+	})
+	if found == nil {
+		return reflect.Value{}, "", false
 	}
-	candidate, _, err := decoder.Decode([]byte(candidateJSON), nil, nil)
-	if err != nil {
-		return "", fmt.Errorf("error decoding into kubernetes object : %v", err)
+	return v.FieldByIndex(found), v.Type().FieldByIndex(found).Tag.Get("json"), true
+}
+
+// deleteEmptiedParents removes the objects on 'path' left empty after deleting its last field.
+// defaulting would fill them back, so they carry no information.
+func deleteEmptiedParents(in string, path string) string {
+	parts := strings.Split(path, ".")
+	for i := len(parts) - 1; i > 1; i-- {
+		parent := strings.Join(parts[:i], ".")
+		p := gjson.Get(in, parent)
+		if !p.IsObject() || len(p.Map()) > 0 {
+			break
+		}
+		if _, err := strconv.Atoi(parts[i-1]); err == nil {
+			// an array element, removing it would shift its siblings
+			break
+		}
+		res, err := sjson.Delete(in, parent)
+		if err != nil {
+			break
+		}
+		in = res
 	}
+	return in
+}
+
+var myscheme *runtime.Scheme
+var decoder runtime.Decoder
 
-	// why this doesn't work?
-	//scheme.Scheme.Default(candidate)
-	myscheme.Default(candidate)
+// schemeBuilder registers the defaulting funcs of the built-in API groups
+var schemeBuilder = runtime.NewSchemeBuilder(
+	admissionregistrationv1.AddToScheme,
+	appsv1.AddToScheme,
+	autoscalingv1.AddToScheme,
+	autoscalingv2.AddToScheme,
+	batchv1.AddToScheme,
+	certificatesv1.AddToScheme,
+	coordinationv1.AddToScheme,
+	apisv1.AddToScheme,
+	discoveryv1.AddToScheme,
+	networkingv1.AddToScheme,
+	policyv1.AddToScheme,
+	rbacv1.AddToScheme,
+	schedulingv1.AddToScheme,
+	storagev1.AddToScheme,
+)
 
-	resJSON, err := json.Marshal(candidate)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling kubernetes object : %v", err)
+func init() {
+	myscheme = runtime.NewScheme()
+	if err := schemeBuilder.AddToScheme(myscheme); err != nil {
+		panic(fmt.Sprintf("error building defaults scheme : %v", err))
 	}
-	defaultValue := gjson.Get(string(resJSON), path).String()
-	return defaultValue, nil
+	decoder = scheme.Codecs.UniversalDeserializer()
 }
@@ -1,21 +1,27 @@
 package defaults
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+	"github.com/jeremywohl/flatten"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
-func TestComputeDefault(t *testing.T) {
+func TestFindDefaults(t *testing.T) {
 	cases := []struct {
 		title  string
-		path   string
 		data   string
-		expect string
+		expect []string
 	}{
 		{
 			title: "PullPolicyAlways",
-			path:  "spec.containers.0.imagePullPolicy",
 			data: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
@@ -27,16 +33,16 @@ func TestComputeDefault(t *testing.T) {
 					"containers": [
 						{
 							"image": "foo",
+							"imagePullPolicy": "Always",
 							"name": "myapp"
 						}
 					]
 				}
 			}`,
-			expect: "Always",
+			expect: []string{"spec.containers.0.imagePullPolicy"},
 		},
 		{
 			title: "PullPolicyIfNotPresent",
-			path:  "spec.containers.0.imagePullPolicy",
 			data: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
@@ -48,16 +54,16 @@ func TestComputeDefault(t *testing.T) {
 					"containers": [
 						{
 							"image": "foo:bar",
+							"imagePullPolicy": "IfNotPresent",
 							"name": "myapp"
 						}
 					]
 				}
 			}`,
-			expect: "IfNotPresent",
+			expect: []string{"spec.containers.0.imagePullPolicy"},
 		},
 		{
-			title: "RestartPolicy",
-			path:  "spec.restartPolicy",
+			title: "RestartPolicyAndTerminationMessagePath",
 			data: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
@@ -66,19 +72,20 @@ func TestComputeDefault(t *testing.T) {
 					"namespace": "default"
 				},
 				"spec": {
+					"restartPolicy": "Always",
 					"containers": [
 						{
 							"image": "foo:bar",
-							"name": "myapp"
+							"name": "myapp",
+							"terminationMessagePath": "/dev/termination-log"
 						}
 					]
 				}
 			}`,
-			expect: "Always",
+			expect: []string{"spec.containers.0.terminationMessagePath", "spec.restartPolicy"},
 		},
 		{
-			title: "TerminationMessagePath",
-			path:  "spec.containers.0.terminationMessagePath",
+			title: "NotDefault",
 			data: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
@@ -87,40 +94,42 @@ func TestComputeDefault(t *testing.T) {
 					"namespace": "default"
 				},
 				"spec": {
+					"restartPolicy": "Never",
 					"containers": [
 						{
 							"image": "foo:bar",
+							"imagePullPolicy": "Always",
 							"name": "myapp"
 						}
 					]
 				}
 			}`,
-			expect: "/dev/termination-log",
+			expect: nil,
 		},
 	}
 	for _, c := range cases {
-		res, err := computeDefault(c.path, c.data)
+		obj, _, err := decoder.Decode([]byte(c.data), nil, nil)
 		if err != nil {
-			t.Errorf("error in computeDefault for case '%s': %v", c.title, err)
+			t.Errorf("error decoding case '%s': %v", c.title, err)
+			continue
 		}
-		if res != c.expect {
-			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, res)
+		res := findDefaults(obj)
+		sort.Strings(res)
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf("test case '%s' failed. want: '%v' have: '%v'", c.title, c.expect, res)
 		}
 	}
 }
 
-func TestIsDefault(t *testing.T) {
+func TestNeatDefault(t *testing.T) {
 	cases := []struct {
 		title  string
-		path   string
-		value  interface{}
-		object string
-		expect bool
+		data   string
+		expect string
 	}{
 		{
 			title: "PullPolicyAlways",
-			path:  "spec.containers.0.imagePullPolicy",
-			object: `{
+			data: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
 				"metadata": {
@@ -131,18 +140,13 @@ func TestIsDefault(t *testing.T) {
 					"containers": [
 						{
 							"image": "foo",
+							"imagePullPolicy": "Always",
 							"name": "myapp"
 						}
 					]
 				}
 			}`,
-			value:  "Always",
-			expect: true,
-		},
-		{
-			title: "PullPolicyIfNotPresent",
-			path:  "spec.containers.0.imagePullPolicy",
-			object: `{
+			expect: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
 				"metadata": {
@@ -152,19 +156,16 @@ func TestIsDefault(t *testing.T) {
 				"spec": {
 					"containers": [
 						{
-							"image": "foo:bar",
+							"image": "foo",
 							"name": "myapp"
 						}
 					]
 				}
 			}`,
-			value:  "IfNotPresent",
-			expect: true,
 		},
 		{
-			title: "RestartPolicy",
-			path:  "spec.restartPolicy",
-			object: `{
+			title: "PullPolicyIfNotPresent",
+			data: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
 				"metadata": {
@@ -175,18 +176,13 @@ func TestIsDefault(t *testing.T) {
 					"containers": [
 						{
 							"image": "foo:bar",
+							"imagePullPolicy": "IfNotPresent",
 							"name": "myapp"
 						}
 					]
 				}
 			}`,
-			value:  "Always",
-			expect: true,
-		},
-		{
-			title: "TerminationMessagePath",
-			path:  "spec.containers.0.terminationMessagePath",
-			object: `{
+			expect: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
 				"metadata": {
@@ -202,29 +198,9 @@ func TestIsDefault(t *testing.T) {
 					]
 				}
 			}`,
-			value:  "/dev/termination-log",
-			expect: true,
 		},
-	}
-	for _, c := range cases {
-		res, err := isDefault(c.path, c.value, c.object)
-		if err != nil {
-			t.Errorf("error in isDefault for case '%s': %v", c.title, err)
-		}
-		if res != c.expect {
-			t.Errorf("test case '%s' failed. want: '%v' have: '%v'", c.title, c.expect, res)
-		}
-	}
-}
-
-func TestNeatDefault(t *testing.T) {
-	cases := []struct {
-		title  string
-		data   string
-		expect string
-	}{
 		{
-			title: "PullPolicyAlways",
+			title: "RestartPolicy",
 			data: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
@@ -233,10 +209,10 @@ func TestNeatDefault(t *testing.T) {
 					"namespace": "default"
 				},
 				"spec": {
+					"restartPolicy": "Always",
 					"containers": [
 						{
-							"image": "foo",
-							"imagePullPolicy": "Always",
+							"image": "foo:bar",
 							"name": "myapp"
 						}
 					]
@@ -252,7 +228,7 @@ func TestNeatDefault(t *testing.T) {
 				"spec": {
 					"containers": [
 						{
-							"image": "foo",
+							"image": "foo:bar",
 							"name": "myapp"
 						}
 					]
@@ -260,7 +236,7 @@ func TestNeatDefault(t *testing.T) {
 			}`,
 		},
 		{
-			title: "PullPolicyIfNotPresent",
+			title: "TerminationMessagePath",
 			data: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
@@ -271,8 +247,8 @@ func TestNeatDefault(t *testing.T) {
 				"spec": {
 					"containers": [
 						{
+							"terminationMessagePath": "/dev/termination-log",
 							"image": "foo:bar",
-							"imagePullPolicy": "IfNotPresent",
 							"name": "myapp"
 						}
 					]
@@ -296,43 +272,135 @@ func TestNeatDefault(t *testing.T) {
 			}`,
 		},
 		{
-			title: "RestartPolicy",
+			title: "Deployment",
 			data: `{
-				"apiVersion": "v1",
-				"kind": "Pod",
+				"apiVersion": "apps/v1",
+				"kind": "Deployment",
 				"metadata": {
 					"name": "myapp",
 					"namespace": "default"
 				},
 				"spec": {
-					"restartPolicy": "Always",
-					"containers": [
-						{
-							"image": "foo:bar",
-							"name": "myapp"
+					"progressDeadlineSeconds": 600,
+					"replicas": 3,
+					"revisionHistoryLimit": 10,
+					"selector": {
+						"matchLabels": {
+							"app": "myapp"
 						}
-					]
+					},
+					"strategy": {
+						"rollingUpdate": {
+							"maxSurge": "25%",
+							"maxUnavailable": "25%"
+						},
+						"type": "RollingUpdate"
+					},
+					"template": {
+						"metadata": {
+							"labels": {
+								"app": "myapp"
+							}
+						},
+						"spec": {
+							"containers": [
+								{
+									"image": "foo:bar",
+									"imagePullPolicy": "IfNotPresent",
+									"name": "myapp",
+									"terminationMessagePath": "/dev/termination-log"
+								}
+							],
+							"restartPolicy": "Always"
+						}
+					}
 				}
 			}`,
 			expect: `{
-				"apiVersion": "v1",
-				"kind": "Pod",
+				"apiVersion": "apps/v1",
+				"kind": "Deployment",
 				"metadata": {
 					"name": "myapp",
 					"namespace": "default"
 				},
 				"spec": {
-					"containers": [
-						{
-							"image": "foo:bar",
-							"name": "myapp"
+					"replicas": 3,
+					"selector": {
+						"matchLabels": {
+							"app": "myapp"
 						}
-					]
+					},
+					"template": {
+						"metadata": {
+							"labels": {
+								"app": "myapp"
+							}
+						},
+						"spec": {
+							"containers": [
+								{
+									"image": "foo:bar",
+									"name": "myapp"
+								}
+							]
+						}
+					}
 				}
 			}`,
 		},
 		{
-			title: "TerminationMessagePath",
+			title: "Job",
+			data: `{
+				"apiVersion": "batch/v1",
+				"kind": "Job",
+				"metadata": {
+					"name": "myjob",
+					"namespace": "default"
+				},
+				"spec": {
+					"backoffLimit": 6,
+					"completionMode": "NonIndexed",
+					"completions": 1,
+					"parallelism": 1,
+					"suspend": false,
+					"template": {
+						"spec": {
+							"containers": [
+								{
+									"image": "foo:bar",
+									"name": "myjob"
+								}
+							],
+							"restartPolicy": "Never"
+						}
+					}
+				}
+			}`,
+			expect: `{
+				"apiVersion": "batch/v1",
+				"kind": "Job",
+				"metadata": {
+					"name": "myjob",
+					"namespace": "default"
+				},
+				"spec": {
+					"completions": 1,
+					"template": {
+						"spec": {
+							"containers": [
+								{
+									"image": "foo:bar",
+									"name": "myjob"
+								}
+							],
+							"restartPolicy": "Never"
+						}
+					}
+				}
+			}`,
+		},
+		{
+			title: "HostPortFromHostNetwork",
 			data: `{
 				"apiVersion": "v1",
 				"kind": "Pod",
@@ -341,11 +409,18 @@ func TestNeatDefault(t *testing.T) {
 					"namespace": "default"
 				},
 				"spec": {
+					"hostNetwork": true,
 					"containers": [
 						{
-							"terminationMessagePath": "/dev/termination-log",
 							"image": "foo:bar",
-							"name": "myapp"
+							"name": "myapp",
+							"ports": [
+								{
+									"containerPort": 8080,
+									"hostPort": 8080,
+									"protocol": "TCP"
+								}
+							]
 						}
 					]
 				}
@@ -358,10 +433,16 @@ func TestNeatDefault(t *testing.T) {
 					"namespace": "default"
 				},
 				"spec": {
+					"hostNetwork": true,
 					"containers": [
 						{
 							"image": "foo:bar",
-							"name": "myapp"
+							"name": "myapp",
+							"ports": [
+								{
+									"containerPort": 8080
+								}
+							]
 						}
 					]
 				}
@@ -418,6 +499,16 @@ func TestNeatDefault(t *testing.T) {
 				}
 			}`,
 		},
+		{
+			title:  "empty objects carrying no information",
+			data:   `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "myapp"}, "spec": {"securityContext": {}, "containers": [{"name": "myapp", "image": "foo:bar", "resources": {}}]}}`,
+			expect: `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "myapp"}, "spec": {"containers": [{"name": "myapp", "image": "foo:bar"}]}}`,
+		},
+		{
+			title:  "required empty object",
+			data:   `{"apiVersion": "networking.k8s.io/v1", "kind": "NetworkPolicy", "metadata": {"name": "deny"}, "spec": {"podSelector": {}}}`,
+			expect: `{"apiVersion": "networking.k8s.io/v1", "kind": "NetworkPolicy", "metadata": {"name": "deny"}, "spec": {"podSelector": {}}}`,
+		},
 	}
 	for _, c := range cases {
 		resJSON, err := NeatDefaults(c.data)
@@ -435,3 +526,97 @@ func TestNeatDefault(t *testing.T) {
 		}
 	}
 }
+
+// neatDefaultsPerField is the former implementation, one decode/default/encode cycle per leaf field.
+// it's kept as the baseline for the benchmarks
+func neatDefaultsPerField(in string) (string, error) {
+	var jParsed map[string]interface{}
+	err := json.Unmarshal([]byte(gjson.Get(in, "spec").Raw), &jParsed)
+	if err != nil {
+		return "", err
+	}
+	pathsToDelete, err := flatten.Flatten(jParsed, "spec.", flatten.DotStyle)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range pathsToDelete {
+		computed, err := computeDefaultPerField(k, in)
+		if err != nil || computed != fmt.Sprintf("%v", v) {
+			delete(pathsToDelete, k)
+		}
+	}
+	for k := range pathsToDelete {
+		in, _ = sjson.Delete(in, k)
+	}
+	return in, nil
+}
+
+// computeDefaultPerField returns the default value for the 'path' (gjson path) to field in 'objJSON'
+func computeDefaultPerField(path string, objJSON string) (string, error) {
+	candidateJSON, err := sjson.Delete(objJSON, path)
+	if err != nil {
+		return "", err
+	}
+	candidate, _, err := decoder.Decode([]byte(candidateJSON), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	myscheme.Default(candidate)
+	resJSON, err := json.Marshal(candidate)
+	if err != nil {
+		return "", err
+	}
+	return gjson.GetBytes(resJSON, path).String(), nil
+}
+
+// bigDeployment builds a Deployment with many containers and env vars, all defaults filled in
+func bigDeployment(containers, envs int) string {
+	draft := `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "big", "namespace": "default"},
+		"spec": {"progressDeadlineSeconds": 600, "replicas": 1, "revisionHistoryLimit": 10,
+		"selector": {"matchLabels": {"app": "big"}},
+		"template": {"metadata": {"labels": {"app": "big"}}, "spec": {"dnsPolicy": "ClusterFirst", "restartPolicy": "Always",
+		"schedulerName": "default-scheduler", "terminationGracePeriodSeconds": 30, "containers": []}}}}`
+	for c := 0; c < containers; c++ {
+		container := fmt.Sprintf(`{"name": "c%d", "image": "foo:bar", "imagePullPolicy": "IfNotPresent",
+			"terminationMessagePath": "/dev/termination-log", "terminationMessagePolicy": "File",
+			"ports": [{"containerPort": 8080, "protocol": "TCP"}], "env": []}`, c)
+		for e := 0; e < envs; e++ {
+			container, _ = sjson.Set(container, "env.-1", map[string]string{"name": fmt.Sprintf("E%d", e), "value": "v"})
+		}
+		draft, _ = sjson.SetRaw(draft, "spec.template.spec.containers.-1", container)
+	}
+	return draft
+}
+
+func BenchmarkNeatDefaults(b *testing.B) {
+	type input struct {
+		name string
+		data string
+	}
+	inputs := []input{{"deployment-10x20", bigDeployment(10, 20)}}
+	for _, name := range []string{"pod1", "service1", "pv1"} {
+		data, err := os.ReadFile(fmt.Sprintf("../../test/fixtures/%s-raw.json", name))
+		if err != nil {
+			b.Fatalf("can't read fixture %s: %v", name, err)
+		}
+		inputs = append(inputs, input{name, string(data)})
+	}
+	impls := []struct {
+		name string
+		fn   func(string) (string, error)
+	}{
+		{"single-pass", NeatDefaults},
+		{"per-field", neatDefaultsPerField},
+	}
+	for _, in := range inputs {
+		for _, impl := range impls {
+			b.Run(in.name+"/"+impl.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := impl.fn(in.data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package defaults
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// structure is a struct value inside a typed object, together with its leaf fields that are set
+type structure struct {
+	steps  []step
+	prefix string
+	leaves []leaf
+}
+
+// step is one move from a value to a nested value: a struct field, or a slice element when index is nil
+type step struct {
+	index []int
+	elem  int
+}
+
+// leaf is a scalar-like field of a structure: the field index and its json name
+type leaf struct {
+	index []int
+	name  string
+}
+
+// candidate is a leaf of a specific structure
+type candidate struct {
+	s *structure
+	l leaf
+}
+
+func (c candidate) path() string {
+	return join(c.s.prefix, c.l.name)
+}
+
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// skippedTopLevel are fields defaulting isn't about
+var skippedTopLevel = map[string]bool{"apiVersion": true, "kind": true, "metadata": true, "status": true}
+
+// findDefaults returns the json paths of the fields of 'obj' that hold their default value.
+//
+// The object is decoded once. All of its structures are collected with the leaf fields that are set,
+// then in round r a deep copy of the object with the r-th leaf of every structure of a given depth zeroed out
+// is defaulted, and each zeroed leaf that comes back with its original value is a candidate.
+// The number of defaulting rounds depends on the widest structure of each depth, not on how many
+// containers or env vars the object has. A final check defaults the object with all candidates zeroed
+// together, and drops the ones that don't come back, until all do.
+func findDefaults(obj runtime.Object) []string {
+	root := reflect.ValueOf(obj)
+	var structures []*structure
+	collectStructures(deref(root), nil, "", true, &structures)
+
+	// structures at the same depth are probed together. a field default may depend on fields
+	// of its ancestors, e.g. hostPort on hostNetwork, so those are never zeroed at the same time
+	byDepth := map[int][]*structure{}
+	maxDepth := 0
+	for _, s := range structures {
+		byDepth[len(s.steps)] = append(byDepth[len(s.steps)], s)
+		if len(s.steps) > maxDepth {
+			maxDepth = len(s.steps)
+		}
+	}
+	var candidates []candidate
+	for depth := 0; depth <= maxDepth; depth++ {
+		for r := 0; ; r++ {
+			var probes []candidate
+			for _, s := range byDepth[depth] {
+				if r < len(s.leaves) {
+					probes = append(probes, candidate{s, s.leaves[r]})
+				}
+			}
+			if len(probes) == 0 {
+				break
+			}
+			candidates = append(candidates, restored(obj, probes)...)
+		}
+	}
+	for len(candidates) > 0 {
+		confirmed := restored(obj, candidates)
+		if len(confirmed) == len(candidates) {
+			break
+		}
+		candidates = confirmed
+	}
+
+	var res []string
+	for _, c := range candidates {
+		res = append(res, c.path())
+	}
+	return res
+}
+
+// restored zeroes the 'probes' in a copy of 'obj', defaults it and returns the probes that got back their original value
+func restored(obj runtime.Object, probes []candidate) []candidate {
+	cp := obj.DeepCopyObject()
+	cpRoot := reflect.ValueOf(cp)
+	for _, p := range probes {
+		f := locateField(cpRoot, p)
+		if f.IsValid() {
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	myscheme.Default(cp)
+
+	origRoot := reflect.ValueOf(obj)
+	var res []candidate
+	for _, p := range probes {
+		f := locateField(cpRoot, p)
+		if !f.IsValid() {
+			continue
+		}
+		if sameJSON(locateField(origRoot, p).Interface(), f.Interface()) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// collectStructures walks the struct value 'v' and appends it and all its nested structures to 'res'
+func collectStructures(v reflect.Value, steps []step, prefix string, topLevel bool, res *[]*structure) {
+	s := &structure{steps: steps, prefix: prefix}
+	walkFields(v.Type(), nil, func(index []int, name string, t reflect.Type) {
+		if topLevel && skippedTopLevel[name] {
+			return
+		}
+		fv := v.FieldByIndex(index)
+		fSteps := append(append([]step{}, steps...), step{index: index})
+		fPrefix := join(prefix, name)
+		switch {
+		case isLeaf(t):
+			if !fv.IsZero() {
+				s.leaves = append(s.leaves, leaf{index: index, name: name})
+			}
+		case t.Kind() == reflect.Slice:
+			for i := 0; i < fv.Len(); i++ {
+				ev := deref(fv.Index(i))
+				if ev.IsValid() {
+					eSteps := append(append([]step{}, fSteps...), step{elem: i})
+					collectStructures(ev, eSteps, join(fPrefix, strconv.Itoa(i)), false, res)
+				}
+			}
+		default:
+			if sv := deref(fv); sv.IsValid() {
+				collectStructures(sv, fSteps, fPrefix, false, res)
+			}
+		}
+	})
+	if len(s.leaves) > 0 {
+		*res = append(*res, s)
+	}
+}
+
+// walkFields calls 'fn' for every json field of the struct type 't', flattening inlined embedded structs
+func walkFields(t reflect.Type, parent []int, fn func(index []int, name string, t reflect.Type)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		index := append(append([]int{}, parent...), i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			walkFields(f.Type, index, fn)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fn(index, name, f.Type)
+	}
+}
+
+// isLeaf tells if fields of type 't' are compared as a whole rather than walked into
+func isLeaf(t reflect.Type) bool {
+	if t.Implements(jsonMarshaler) || reflect.PtrTo(t).Implements(jsonMarshaler) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return isLeaf(t.Elem())
+	case reflect.Struct:
+		return false
+	case reflect.Slice:
+		e := t.Elem()
+		if e.Kind() == reflect.Ptr {
+			e = e.Elem()
+		}
+		return e.Kind() != reflect.Struct || isLeaf(e)
+	}
+	return true
+}
+
+// locateField finds the field of candidate 'c' in the object 'root', or returns the zero Value if it's gone
+func locateField(root reflect.Value, c candidate) reflect.Value {
+	v := deref(root)
+	for _, st := range c.s.steps {
+		if !v.IsValid() {
+			return reflect.Value{}
+		}
+		if st.index != nil {
+			v = v.FieldByIndex(st.index)
+		} else {
+			if st.elem >= v.Len() {
+				return reflect.Value{}
+			}
+			v = v.Index(st.elem)
+		}
+		v = deref(v)
+	}
+	if !v.IsValid() {
+		return reflect.Value{}
+	}
+	return v.FieldByIndex(c.l.index)
+}
+
+// deref follows pointers, returning the zero Value for nil
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// sameJSON compares two values by their json representation, which is what ends up in the manifest
+func sameJSON(a, b interface{}) bool {
+	aj, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bj, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(aj) == string(bj)
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,266 @@
+// Package helm scaffolds a Helm chart out of exported objects, with their common knobs in values.yaml.
+package helm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/paths"
+	"github.com/ghodss/yaml"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// File is a file of the chart, relative to its directory
+type File struct {
+	Path string
+	Data []byte
+}
+
+// podSpecs are the paths of the pod specs of the workload kinds
+var podSpecs = map[string]string{
+	"Pod":         "spec",
+	"Deployment":  "spec.template.spec",
+	"StatefulSet": "spec.template.spec",
+	"DaemonSet":   "spec.template.spec",
+	"ReplicaSet":  "spec.template.spec",
+	"Job":         "spec.template.spec",
+	"CronJob":     "spec.jobTemplate.spec.template.spec",
+}
+
+// replicated are the kinds whose spec.replicas is a value
+var replicated = map[string]bool{"Deployment": true, "StatefulSet": true, "ReplicaSet": true}
+
+// chart collects the values and the placeholders of the templates while they're built
+type chart struct {
+	values map[string]interface{}
+	// keys are the values keys of the workloads, to avoid clashes between kinds
+	keys map[string]bool
+	// placeholders are replaced by their template action once the templates are yaml
+	placeholders []placeholder
+}
+
+type placeholder struct {
+	token  string
+	action func(line string) string
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// Build makes the chart 'name' of the json objects: Chart.yaml, values.yaml and a template per object.
+// the objects lose their namespace, the chart is installed in the release namespace
+func Build(name string, objects [][]byte) ([]File, error) {
+	c := &chart{values: map[string]interface{}{}, keys: map[string]bool{"configMaps": true}}
+	namespace := ""
+	var templates []File
+	seen := map[string]bool{}
+	for _, obj := range objects {
+		ns := gjson.GetBytes(obj, "metadata.namespace").String()
+		if ns != "" {
+			if namespace != "" && ns != namespace {
+				return nil, fmt.Errorf("a chart is made of the objects of a single namespace, have %s and %s", namespace, ns)
+			}
+			namespace = ns
+		}
+		kind := gjson.GetBytes(obj, "kind").String()
+		objName := gjson.GetBytes(obj, "metadata.name").String()
+		file := fmt.Sprintf("templates/%s-%s.yaml", strings.ToLower(kind), objName)
+		if seen[file] {
+			return nil, fmt.Errorf("%s %s is exported twice", kind, objName)
+		}
+		seen[file] = true
+
+		obj, err := sjson.DeleteBytes(obj, "metadata.namespace")
+		if err != nil {
+			return nil, err
+		}
+		obj, err = c.template(kind, objName, obj)
+		if err != nil {
+			return nil, fmt.Errorf("error templating %s %s : %v", kind, objName, err)
+		}
+		data, err := yaml.JSONToYAML(obj)
+		if err != nil {
+			return nil, fmt.Errorf("error converting from json to yaml : %v", err)
+		}
+		templates = append(templates, File{Path: file, Data: c.render(data)})
+	}
+
+	chartYAML, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion":  "v2",
+		"name":        name,
+		"description": "A Helm chart exported by kubectl-neatx",
+		"type":        "application",
+		"version":     "0.1.0",
+	})
+	if err != nil {
+		return nil, err
+	}
+	values, err := yaml.Marshal(c.values)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling values : %v", err)
+	}
+	return append([]File{{Path: "Chart.yaml", Data: chartYAML}, {Path: "values.yaml", Data: values}}, templates...), nil
+}
+
+// template replaces the knobs of an object with placeholders, and records their values
+func (c *chart) template(kind, name string, obj []byte) ([]byte, error) {
+	var err error
+	if kind == "ConfigMap" {
+		data := map[string]interface{}{}
+		gjson.GetBytes(obj, "data").ForEach(func(k, v gjson.Result) bool {
+			data[k.String()] = v.String()
+			obj, err = sjson.SetBytes(obj, "data."+paths.Escape(k.String()), c.placeholder(func(line string) string {
+				return fmt.Sprintf(`{{ index .Values.configMaps %q %q | toJson }}`, name, k.String())
+			}))
+			return err == nil
+		})
+		if len(data) > 0 {
+			configMaps, _ := c.values["configMaps"].(map[string]interface{})
+			if configMaps == nil {
+				configMaps = map[string]interface{}{}
+				c.values["configMaps"] = configMaps
+			}
+			configMaps[name] = data
+		}
+		return obj, err
+	}
+
+	podSpec, ok := podSpecs[kind]
+	if !ok {
+		return obj, nil
+	}
+	key := c.key(kind, name)
+	values := map[string]interface{}{}
+	if replicas := gjson.GetBytes(obj, "spec.replicas"); replicated[kind] && replicas.Exists() {
+		values["replicas"] = replicas.Value()
+		obj, err = sjson.SetBytes(obj, "spec.replicas", c.placeholder(func(line string) string {
+			return fmt.Sprintf("{{ .Values.%s.replicas }}", key)
+		}))
+		if err != nil {
+			return nil, err
+		}
+	}
+	containers := map[string]interface{}{}
+	for _, field := range []string{"initContainers", "containers"} {
+		for i, container := range gjson.GetBytes(obj, podSpec+"."+field).Array() {
+			path := fmt.Sprintf("%s.%s.%d", podSpec, field, i)
+			ref := fmt.Sprintf(".Values.%s.containers.%s", key, valuesKey(container.Get("name").String()))
+			values := map[string]interface{}{}
+			if image := container.Get("image"); image.Exists() {
+				repository, sep, tag := splitImage(image.String())
+				values["image"] = map[string]interface{}{"repository": repository, "tag": tag}
+				action := fmt.Sprintf(`"{{ %s.image.repository }}{{ with %s.image.tag }}%s{{ . }}{{ end }}"`, ref, ref, sep)
+				obj, err = sjson.SetBytes(obj, path+".image", c.placeholder(func(string) string { return action }))
+				if err != nil {
+					return nil, err
+				}
+			}
+			if resources := container.Get("resources"); resources.Exists() {
+				values["resources"] = resources.Value()
+				obj, err = sjson.SetBytes(obj, path+".resources", c.placeholder(func(line string) string {
+					// the resources go on their own lines, below the key
+					indent := strings.Index(line, "resources:") + 2
+					return fmt.Sprintf("{{- toYaml %s.resources | nindent %d }}", ref, indent)
+				}))
+				if err != nil {
+					return nil, err
+				}
+			}
+			env := map[string]interface{}{}
+			for j, e := range container.Get("env").Array() {
+				// only literal values, valueFrom references stay in the template
+				value := e.Get("value")
+				if !value.Exists() {
+					continue
+				}
+				envName := e.Get("name").String()
+				env[envName] = value.String()
+				obj, err = sjson.SetBytes(obj, fmt.Sprintf("%s.env.%d.value", path, j), c.placeholder(func(string) string {
+					return fmt.Sprintf(`{{ index %s.env %q | toJson }}`, ref, envName)
+				}))
+				if err != nil {
+					return nil, err
+				}
+			}
+			if len(env) > 0 {
+				values["env"] = env
+			}
+			if len(values) > 0 {
+				containers[valuesKey(container.Get("name").String())] = values
+			}
+		}
+	}
+	if len(containers) > 0 {
+		values["containers"] = containers
+	}
+	if len(values) > 0 {
+		c.values[key] = values
+	}
+	return obj, nil
+}
+
+// placeholder returns a new token, replaced by the result of 'action' on its yaml line once the template is rendered
+func (c *chart) placeholder(action func(line string) string) string {
+	token := fmt.Sprintf("__neatx_placeholder_%d__", len(c.placeholders))
+	c.placeholders = append(c.placeholders, placeholder{token: token, action: action})
+	return token
+}
+
+// render escapes the template delimiters the object holds, then replaces the placeholders by their actions
+func (c *chart) render(data []byte) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(data), "{{", `{{ "{{" }}`), "\n")
+	for i, line := range lines {
+		if !strings.Contains(line, "__neatx_placeholder_") {
+			continue
+		}
+		for _, p := range c.placeholders {
+			if strings.Contains(line, p.token) {
+				lines[i] = strings.Replace(line, p.token, p.action(line), 1)
+				break
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// key is the values key of a workload: its name in lower camel case, prefixed by its kind if another workload has it
+func (c *chart) key(kind, name string) string {
+	key := valuesKey(name)
+	if c.keys[key] {
+		key = valuesKey(kind + "-" + name)
+	}
+	c.keys[key] = true
+	return key
+}
+
+// valuesKey turns a name into a lower camel case key that templates can reference with a dotted path, e.g. my-app to myApp
+func valuesKey(name string) string {
+	var b strings.Builder
+	for i, part := range nonAlphanumeric.Split(name, -1) {
+		if part == "" {
+			continue
+		}
+		if i == 0 || b.Len() == 0 {
+			b.WriteString(strings.ToLower(part[:1]) + part[1:])
+		} else {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	key := b.String()
+	if key == "" || (key[0] >= '0' && key[0] <= '9') {
+		key = "_" + key
+	}
+	return key
+}
+
+// splitImage splits an image reference into its repository and its tag or digest, with the separator between them
+func splitImage(image string) (string, string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], "@", image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], ":", image[i+1:]
+	}
+	return image, ":", ""
+}
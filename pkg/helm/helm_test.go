@@ -0,0 +1,128 @@
+package helm
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+	"github.com/ghodss/yaml"
+	"github.com/tidwall/sjson"
+)
+
+// renderFuncs are the few helm functions the templates use
+var renderFuncs = template.FuncMap{
+	"toJson": func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+	"toYaml": func(v interface{}) string {
+		b, _ := yaml.Marshal(v)
+		return strings.TrimSuffix(string(b), "\n")
+	},
+	"nindent": func(n int, s string) string {
+		pad := strings.Repeat(" ", n)
+		return "\n" + pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+}
+
+func TestBuild(t *testing.T) {
+	deploy := `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "my-app", "namespace": "app1", "annotations": {"summary": "{{ $labels.pod }} is down"}},
+		"spec": {"replicas": 3, "template": {"spec": {
+			"initContainers": [{"name": "init", "image": "busybox"}],
+			"containers": [{"name": "app", "image": "registry.local:5000/team/app:1.2.3", "resources": {"limits": {"cpu": "1", "memory": "1Gi"}},
+					"env": [{"name": "LOG_LEVEL", "value": "debug"}, {"name": "GREETING", "value": "say \"hi\" {{ .name }}"},
+						{"name": "POD_IP", "valueFrom": {"fieldRef": {"fieldPath": "status.podIP"}}}, {"name": "EMPTY", "value": ""}]},
+				{"name": "sidecar", "image": "envoy@sha256:abcd"}]}}}}`
+	cronJob := `{"apiVersion": "batch/v1", "kind": "CronJob", "metadata": {"name": "my-app", "namespace": "app1"},
+		"spec": {"schedule": "* * * * *", "jobTemplate": {"spec": {"template": {"spec": {"containers": [{"name": "job", "image": "app:2"}]}}}}}}`
+	cm := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "app-env", "namespace": "app1"}, "data": {"LOG_LEVEL": "debug", "app.properties": "a=1\nb=\"2\"\n"}}`
+	svc := `{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "my-app", "namespace": "app1"}, "spec": {"ports": [{"port": 80}]}}`
+
+	files, err := Build("app", [][]byte{[]byte(deploy), []byte(cronJob), []byte(cm), []byte(svc)})
+	if err != nil {
+		t.Fatalf("error building chart: %v", err)
+	}
+	byPath := map[string][]byte{}
+	var paths []string
+	for _, f := range files {
+		byPath[f.Path] = f.Data
+		paths = append(paths, f.Path)
+	}
+	wantPaths := "Chart.yaml values.yaml templates/deployment-my-app.yaml templates/cronjob-my-app.yaml templates/configmap-app-env.yaml templates/service-my-app.yaml"
+	if strings.Join(paths, " ") != wantPaths {
+		t.Fatalf("want: '%s' have: '%s'", wantPaths, strings.Join(paths, " "))
+	}
+
+	valuesJSON, _ := yaml.YAMLToJSON(byPath["values.yaml"])
+	wantValues := `{
+		"myApp": {"replicas": 3, "containers": {
+			"init": {"image": {"repository": "busybox", "tag": ""}},
+			"app": {"image": {"repository": "registry.local:5000/team/app", "tag": "1.2.3"}, "resources": {"limits": {"cpu": "1", "memory": "1Gi"}},
+				"env": {"LOG_LEVEL": "debug", "GREETING": "say \"hi\" {{ .name }}", "EMPTY": ""}},
+			"sidecar": {"image": {"repository": "envoy", "tag": "sha256:abcd"}}}},
+		"cronJobMyApp": {"containers": {"job": {"image": {"repository": "app", "tag": "2"}}}},
+		"configMaps": {"app-env": {"LOG_LEVEL": "debug", "app.properties": "a=1\nb=\"2\"\n"}}}`
+	equal, err := testutil.JSONEqual(string(valuesJSON), wantValues)
+	if err != nil || !equal {
+		t.Errorf("values differ. want: '%s' have: '%s'", wantValues, valuesJSON)
+	}
+	if !strings.Contains(string(byPath["templates/deployment-my-app.yaml"]), "{{ .Values.myApp.replicas }}") {
+		t.Errorf("replicas not templated: %s", byPath["templates/deployment-my-app.yaml"])
+	}
+	if !strings.Contains(string(byPath["templates/deployment-my-app.yaml"]), `{{ index .Values.myApp.containers.app.env "LOG_LEVEL" | toJson }}`) {
+		t.Errorf("env not templated: %s", byPath["templates/deployment-my-app.yaml"])
+	}
+
+	// rendering the templates with the values gives back the objects, without their namespace
+	var values map[string]interface{}
+	json.Unmarshal(valuesJSON, &values)
+	for path, want := range map[string]string{
+		"templates/deployment-my-app.yaml": deploy,
+		"templates/cronjob-my-app.yaml":    cronJob,
+		"templates/configmap-app-env.yaml": cm,
+		"templates/service-my-app.yaml":    svc,
+	} {
+		tmpl, err := template.New(path).Funcs(renderFuncs).Parse(string(byPath[path]))
+		if err != nil {
+			t.Errorf("error parsing %s: %v", path, err)
+			continue
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, map[string]interface{}{"Values": values}); err != nil {
+			t.Errorf("error rendering %s: %v", path, err)
+			continue
+		}
+		have, err := yaml.YAMLToJSON(out.Bytes())
+		if err != nil {
+			t.Errorf("error decoding rendered %s: %v\n%s", path, err, out.String())
+			continue
+		}
+		want, _ = sjson.Delete(want, "metadata.namespace")
+		equal, err := testutil.JSONEqual(string(have), want)
+		if err != nil || !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", path, want, have)
+		}
+	}
+}
+
+func TestBuildNamespaces(t *testing.T) {
+	objects := [][]byte{
+		[]byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "a", "namespace": "ns1"}}`),
+		[]byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "b", "namespace": "ns2"}}`),
+	}
+	if _, err := Build("app", objects); err == nil {
+		t.Errorf("want error for objects of several namespaces")
+	}
+}
+
+func TestValuesKey(t *testing.T) {
+	cases := map[string]string{"web": "web", "my-app": "myApp", "Deployment-my.app": "deploymentMyApp", "1st": "_1st"}
+	for name, want := range cases {
+		if have := valuesKey(name); have != want {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", name, want, have)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Package kustomize turns exported objects into a kustomize base.
+package kustomize
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/paths"
+	"github.com/ghodss/yaml"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Resource is an object of the base, at its path relative to the kustomization
+type Resource struct {
+	Path string
+	JSON []byte
+}
+
+// File is a file of the base, relative to the kustomization
+type File struct {
+	Path string
+	Data []byte
+}
+
+// Options are the optional rewrites of Build
+type Options struct {
+	// CommonLabels moves the labels all objects have to commonLabels
+	CommonLabels bool
+	// Generators turns ConfigMaps and Secrets into configMapGenerator and secretGenerator entries,
+	// with a file per key
+	Generators bool
+}
+
+// Base is a kustomization with its resources and data files
+type Base struct {
+	Kustomization []byte
+	Resources     []Resource
+	Files         []File
+}
+
+type kustomization struct {
+	APIVersion         string            `json:"apiVersion"`
+	Kind               string            `json:"kind"`
+	Namespace          string            `json:"namespace,omitempty"`
+	CommonLabels       map[string]string `json:"commonLabels,omitempty"`
+	Resources          []string          `json:"resources,omitempty"`
+	ConfigMapGenerator []generator       `json:"configMapGenerator,omitempty"`
+	SecretGenerator    []generator       `json:"secretGenerator,omitempty"`
+}
+
+type generator struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type,omitempty"`
+	Files   []string          `json:"files,omitempty"`
+	Options *generatorOptions `json:"options,omitempty"`
+}
+
+type generatorOptions struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// the generated objects keep their name, so the objects referencing them don't change
+	DisableNameSuffixHash bool `json:"disableNameSuffixHash"`
+}
+
+// generatedFields are the fields of a ConfigMap or a Secret a generator can express
+var generatedFields = map[string]bool{"apiVersion": true, "kind": true, "metadata": true, "data": true, "binaryData": true, "type": true}
+
+var generatedMetadata = map[string]bool{"name": true, "namespace": true, "labels": true, "annotations": true}
+
+// Build makes the kustomize base of the objects of a namespace, or of cluster scoped objects when 'namespace' is empty
+func Build(namespace string, resources []Resource, opts Options) (*Base, error) {
+	k := kustomization{APIVersion: "kustomize.config.k8s.io/v1beta1", Kind: "Kustomization", Namespace: namespace}
+	base := &Base{}
+
+	if opts.CommonLabels {
+		k.CommonLabels = commonLabels(resources)
+		if len(k.CommonLabels) > 0 {
+			var err error
+			if resources, err = withoutLabels(resources, k.CommonLabels); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, r := range resources {
+		if opts.Generators && generated(r.JSON) {
+			g, files, err := toGenerator(r)
+			if err != nil {
+				return nil, err
+			}
+			base.Files = append(base.Files, files...)
+			if gjson.GetBytes(r.JSON, "kind").String() == "Secret" {
+				k.SecretGenerator = append(k.SecretGenerator, g)
+			} else {
+				k.ConfigMapGenerator = append(k.ConfigMapGenerator, g)
+			}
+			continue
+		}
+		k.Resources = append(k.Resources, r.Path)
+		base.Resources = append(base.Resources, r)
+	}
+
+	data, err := yaml.Marshal(k)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling kustomization : %v", err)
+	}
+	base.Kustomization = data
+	return base, nil
+}
+
+// commonLabels are the labels with the same value on all objects
+func commonLabels(resources []Resource) map[string]string {
+	if len(resources) == 0 {
+		return nil
+	}
+	common := map[string]string{}
+	gjson.GetBytes(resources[0].JSON, "metadata.labels").ForEach(func(k, v gjson.Result) bool {
+		common[k.String()] = v.String()
+		return true
+	})
+	for _, r := range resources[1:] {
+		labels := gjson.GetBytes(r.JSON, "metadata.labels").Map()
+		for k, v := range common {
+			if l, ok := labels[k]; !ok || l.String() != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
+
+// withoutLabels removes 'labels' from the objects, and their labels field once it's empty
+func withoutLabels(resources []Resource, labels map[string]string) ([]Resource, error) {
+	var res []Resource
+	for _, r := range resources {
+		obj := string(r.JSON)
+		var err error
+		for k := range labels {
+			obj, err = sjson.Delete(obj, "metadata.labels."+paths.Escape(k))
+			if err != nil {
+				return nil, fmt.Errorf("error deleting label %s : %v", k, err)
+			}
+		}
+		if len(gjson.Get(obj, "metadata.labels").Map()) == 0 {
+			obj, _ = sjson.Delete(obj, "metadata.labels")
+		}
+		res = append(res, Resource{Path: r.Path, JSON: []byte(obj)})
+	}
+	return res, nil
+}
+
+// generated tells if an object is a ConfigMap or a Secret a generator can fully express
+func generated(obj []byte) bool {
+	kind := gjson.GetBytes(obj, "kind").String()
+	if gjson.GetBytes(obj, "apiVersion").String() != "v1" || (kind != "ConfigMap" && kind != "Secret") {
+		return false
+	}
+	ok := true
+	gjson.ParseBytes(obj).ForEach(func(k, _ gjson.Result) bool {
+		ok = generatedFields[k.String()]
+		return ok
+	})
+	gjson.GetBytes(obj, "metadata").ForEach(func(k, _ gjson.Result) bool {
+		ok = ok && generatedMetadata[k.String()]
+		return ok
+	})
+	return ok
+}
+
+// toGenerator turns a ConfigMap or a Secret into a generator entry, with its data in files next to where
+// the object would have been written, e.g. configmaps/app/ for configmaps/app.yaml
+func toGenerator(r Resource) (generator, []File, error) {
+	obj := gjson.ParseBytes(r.JSON)
+	name := obj.Get("metadata.name").String()
+	g := generator{Name: name, Type: obj.Get("type").String(), Options: &generatorOptions{DisableNameSuffixHash: true}}
+	g.Options.Labels = stringMap(obj.Get("metadata.labels"))
+	g.Options.Annotations = stringMap(obj.Get("metadata.annotations"))
+	dir := path.Join(path.Dir(r.Path), name)
+	secret := obj.Get("kind").String() == "Secret"
+
+	var files []File
+	add := func(key string, data []byte) {
+		p := path.Join(dir, key)
+		files = append(files, File{Path: p, Data: data})
+		g.Files = append(g.Files, p)
+	}
+	var err error
+	obj.Get("data").ForEach(func(k, v gjson.Result) bool {
+		if !secret {
+			add(k.String(), []byte(v.String()))
+			return true
+		}
+		var data []byte
+		data, err = base64.StdEncoding.DecodeString(v.String())
+		if err != nil {
+			err = fmt.Errorf("error decoding key %s of secret %s : %v", k.String(), name, err)
+			return false
+		}
+		add(k.String(), data)
+		return true
+	})
+	if err != nil {
+		return g, nil, err
+	}
+	obj.Get("binaryData").ForEach(func(k, v gjson.Result) bool {
+		var data []byte
+		data, err = base64.StdEncoding.DecodeString(v.String())
+		if err != nil {
+			err = fmt.Errorf("error decoding key %s of config map %s : %v", k.String(), name, err)
+			return false
+		}
+		add(k.String(), data)
+		return true
+	})
+	if err != nil {
+		return g, nil, err
+	}
+	return g, files, nil
+}
+
+func stringMap(v gjson.Result) map[string]string {
+	if !v.IsObject() {
+		return nil
+	}
+	res := map[string]string{}
+	v.ForEach(func(k, v gjson.Result) bool {
+		res[k.String()] = v.String()
+		return true
+	})
+	return res
+}
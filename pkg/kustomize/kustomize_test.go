@@ -0,0 +1,100 @@
+package kustomize
+
+import (
+	"testing"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+	"github.com/ghodss/yaml"
+)
+
+func TestBuild(t *testing.T) {
+	deploy := Resource{Path: "deployments.apps/web.yaml", JSON: []byte(`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "app1", "labels": {"team": "payments", "app.kubernetes.io/name": "web"}}, "spec": {"replicas": 2}}`)}
+	cm := Resource{Path: "configmaps/web.yaml", JSON: []byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "web", "namespace": "app1", "labels": {"team": "payments"}}, "data": {"app.properties": "a=1\n"}, "binaryData": {"logo.png": "iVBO"}}`)}
+	secret := Resource{Path: "secrets/web.yaml", JSON: []byte(`{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "web", "namespace": "app1", "labels": {"team": "payments"}, "annotations": {"a": "b"}}, "type": "Opaque", "data": {"password": "czNjcjN0"}}`)}
+	immutable := Resource{Path: "configmaps/frozen.yaml", JSON: []byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "frozen", "namespace": "app1", "labels": {"team": "payments"}}, "immutable": true, "data": {"a": "b"}}`)}
+
+	cases := []struct {
+		title         string
+		namespace     string
+		opts          Options
+		resources     []Resource
+		kustomization string
+		expect        []Resource
+		files         []File
+	}{
+		{
+			title:         "resources",
+			namespace:     "app1",
+			resources:     []Resource{deploy, cm},
+			kustomization: `{"apiVersion": "kustomize.config.k8s.io/v1beta1", "kind": "Kustomization", "namespace": "app1", "resources": ["deployments.apps/web.yaml", "configmaps/web.yaml"]}`,
+			expect:        []Resource{deploy, cm},
+		},
+		{
+			title:         "cluster scoped",
+			resources:     []Resource{{Path: "persistentvolumes/pv1.yaml", JSON: []byte(`{"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pv1"}}`)}},
+			kustomization: `{"apiVersion": "kustomize.config.k8s.io/v1beta1", "kind": "Kustomization", "resources": ["persistentvolumes/pv1.yaml"]}`,
+			expect:        []Resource{{Path: "persistentvolumes/pv1.yaml", JSON: []byte(`{"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pv1"}}`)}},
+		},
+		{
+			title:         "common labels",
+			namespace:     "app1",
+			opts:          Options{CommonLabels: true},
+			resources:     []Resource{deploy, cm},
+			kustomization: `{"apiVersion": "kustomize.config.k8s.io/v1beta1", "kind": "Kustomization", "namespace": "app1", "commonLabels": {"team": "payments"}, "resources": ["deployments.apps/web.yaml", "configmaps/web.yaml"]}`,
+			expect: []Resource{
+				{Path: deploy.Path, JSON: []byte(`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "app1", "labels": {"app.kubernetes.io/name": "web"}}, "spec": {"replicas": 2}}`)},
+				{Path: cm.Path, JSON: []byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "web", "namespace": "app1"}, "data": {"app.properties": "a=1\n"}, "binaryData": {"logo.png": "iVBO"}}`)},
+			},
+		},
+		{
+			title:     "generators",
+			namespace: "app1",
+			opts:      Options{Generators: true},
+			resources: []Resource{deploy, cm, secret, immutable},
+			kustomization: `{"apiVersion": "kustomize.config.k8s.io/v1beta1", "kind": "Kustomization", "namespace": "app1", "resources": ["deployments.apps/web.yaml", "configmaps/frozen.yaml"],
+				"configMapGenerator": [{"name": "web", "files": ["configmaps/web/app.properties", "configmaps/web/logo.png"], "options": {"labels": {"team": "payments"}, "disableNameSuffixHash": true}}],
+				"secretGenerator": [{"name": "web", "type": "Opaque", "files": ["secrets/web/password"], "options": {"labels": {"team": "payments"}, "annotations": {"a": "b"}, "disableNameSuffixHash": true}}]}`,
+			expect: []Resource{deploy, immutable},
+			files: []File{
+				{Path: "configmaps/web/app.properties", Data: []byte("a=1\n")},
+				{Path: "configmaps/web/logo.png", Data: []byte{0x89, 0x50, 0x4e}},
+				{Path: "secrets/web/password", Data: []byte("s3cr3t")},
+			},
+		},
+	}
+	for _, c := range cases {
+		base, err := Build(c.namespace, c.resources, c.opts)
+		if err != nil {
+			t.Errorf("test case '%s' failed. error: %v", c.title, err)
+			continue
+		}
+		kustomization, err := yaml.YAMLToJSON(base.Kustomization)
+		if err != nil {
+			t.Errorf("test case '%s' failed. error: %v", c.title, err)
+			continue
+		}
+		equal, err := testutil.JSONEqual(string(kustomization), c.kustomization)
+		if err != nil || !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.kustomization, kustomization)
+		}
+		if len(base.Resources) != len(c.expect) {
+			t.Errorf("test case '%s' failed. want: '%v' have: '%v'", c.title, c.expect, base.Resources)
+			continue
+		}
+		for i, r := range c.expect {
+			equal, err := testutil.JSONEqual(string(base.Resources[i].JSON), string(r.JSON))
+			if err != nil || !equal || base.Resources[i].Path != r.Path {
+				t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, r.JSON, base.Resources[i].JSON)
+			}
+		}
+		if len(base.Files) != len(c.files) {
+			t.Errorf("test case '%s' failed. want: '%v' have: '%v'", c.title, c.files, base.Files)
+			continue
+		}
+		for i, f := range c.files {
+			if base.Files[i].Path != f.Path || string(base.Files[i].Data) != string(f.Data) {
+				t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, f.Path, base.Files[i].Path)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package layout decides where exported objects are written, from a Go template over their identity.
+package layout
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// Object is what layout templates are executed on
+type Object struct {
+	Group   string
+	Version string
+	Kind    string
+	// Resource is the canonical resource name, e.g. deployments.apps or configmaps
+	Resource string
+	// Namespace is empty for cluster scoped objects
+	Namespace string
+	Name      string
+	// Ext is the extension of the output format: yaml or json
+	Ext string
+}
+
+// Presets are the built-in layouts, by name
+var Presets = map[string]string{
+	"by-namespace": `{{if .Namespace}}{{.Namespace}}{{else}}Cluster{{end}}/{{.Resource}}/{{.Name}}.{{.Ext}}`,
+	"by-kind":      `{{.Resource}}/{{if .Namespace}}{{.Namespace}}/{{end}}{{.Name}}.{{.Ext}}`,
+	"flat":         `{{if .Namespace}}{{.Namespace}}-{{end}}{{.Resource}}-{{.Name}}.{{.Ext}}`,
+	"single-file":  `all.{{.Ext}}`,
+}
+
+// Default is the preset used when no layout is given
+const Default = "by-namespace"
+
+var funcs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Layout renders the relative file path of objects
+type Layout struct {
+	tmpl *template.Template
+}
+
+// Parse builds a layout from a preset name or a template
+func Parse(spec string) (*Layout, error) {
+	text := spec
+	if preset, ok := Presets[spec]; ok {
+		text = preset
+	}
+	tmpl, err := template.New("layout").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing layout '%s' : %v", spec, err)
+	}
+	return &Layout{tmpl: tmpl}, nil
+}
+
+// Path renders the relative path of an object. it fails if the path is empty or leaves the destination directory.
+// empty segments are dropped, so {{.Namespace}}/... puts cluster scoped objects at the top
+func (l *Layout) Path(o Object) (string, error) {
+	var b bytes.Buffer
+	if err := l.tmpl.Execute(&b, o); err != nil {
+		return "", fmt.Errorf("error rendering layout : %v", err)
+	}
+	p := path.Clean(strings.TrimLeft(strings.TrimSpace(b.String()), "/"))
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("layout gives '%s' for %s %s/%s, it must be a relative path inside the destination directory", b.String(), o.Kind, o.Namespace, o.Name)
+	}
+	return p, nil
+}
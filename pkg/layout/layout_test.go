@@ -0,0 +1,59 @@
+package layout
+
+import (
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	deploy := Object{Group: "apps", Version: "v1", Kind: "Deployment", Resource: "deployments.apps", Namespace: "app1", Name: "web", Ext: "yaml"}
+	pv := Object{Version: "v1", Kind: "PersistentVolume", Resource: "persistentvolumes", Name: "pv1", Ext: "json"}
+	cases := []struct {
+		title  string
+		layout string
+		object Object
+		expect string
+		err    bool
+	}{
+		{title: "by-namespace", layout: "by-namespace", object: deploy, expect: "app1/deployments.apps/web.yaml"},
+		{title: "by-namespace cluster scoped", layout: "by-namespace", object: pv, expect: "Cluster/persistentvolumes/pv1.json"},
+		{title: "by-kind", layout: "by-kind", object: deploy, expect: "deployments.apps/app1/web.yaml"},
+		{title: "by-kind cluster scoped", layout: "by-kind", object: pv, expect: "persistentvolumes/pv1.json"},
+		{title: "flat", layout: "flat", object: deploy, expect: "app1-deployments.apps-web.yaml"},
+		{title: "single-file", layout: "single-file", object: pv, expect: "all.json"},
+		{title: "template", layout: "{{.Namespace}}/{{.Kind | lower}}-{{.Name}}.yaml", object: deploy, expect: "app1/deployment-web.yaml"},
+		{title: "template cluster scoped", layout: "{{.Namespace}}/{{.Kind | lower}}-{{.Name}}.yaml", object: pv, expect: "persistentvolume-pv1.yaml"},
+		{title: "group and version", layout: "{{.Group}}/{{.Version}}/{{.Name}}", object: deploy, expect: "apps/v1/web"},
+		{title: "outside destination", layout: "../{{.Name}}", object: deploy, err: true},
+		{title: "absolute", layout: "/tmp/{{.Name}}", object: deploy, expect: "tmp/web"},
+		{title: "escaping", layout: "a/../../{{.Name}}", object: deploy, err: true},
+		{title: "empty", layout: "{{.Namespace}}", object: pv, err: true},
+		{title: "unknown field", layout: "{{.Nope}}", object: pv, err: true},
+	}
+	for _, c := range cases {
+		l, err := Parse(c.layout)
+		if err != nil {
+			t.Errorf("test case '%s' failed. error parsing: %v", c.title, err)
+			continue
+		}
+		res, err := l.Path(c.object)
+		if c.err {
+			if err == nil {
+				t.Errorf("test case '%s' failed. want error have: '%s'", c.title, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test case '%s' failed. error: %v", c.title, err)
+			continue
+		}
+		if res != c.expect {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, res)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	if _, err := Parse("{{.Name"); err == nil {
+		t.Errorf("want error for an invalid template")
+	}
+}
@@ -0,0 +1,131 @@
+// Package metadata decides which fields of an object's metadata survive neating.
+package metadata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/paths"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// GeneratedAnnotation flags objects created by a controller, with the controller owner as Kind/name
+const GeneratedAnnotation = "kubectl-neatx.io/generated-by"
+
+// fieldOrder is the order of the kept metadata fields in the output, other fields follow in their original order
+var fieldOrder = []string{"name", "generateName", "namespace", "labels", "annotations", "ownerReferences", "finalizers"}
+
+// Policy keeps or drops metadata fields, for all kinds or per kind. fields it doesn't name are dropped
+type Policy struct {
+	fields map[string]bool
+	kinds  map[string]map[string]bool
+	// FlagGenerated annotates objects that have a controller owner reference with GeneratedAnnotation
+	FlagGenerated bool
+}
+
+// DefaultPolicy keeps name, namespace, labels and annotations. generateName is kept for objects without a name,
+// which can't be created without it
+func DefaultPolicy() *Policy {
+	return &Policy{
+		fields: map[string]bool{"name": true, "namespace": true, "labels": true, "annotations": true},
+		kinds:  map[string]map[string]bool{},
+	}
+}
+
+// Set keeps or drops 'field' for objects of 'kind', or of all kinds if 'kind' is empty
+func (p *Policy) Set(kind string, field string, keep bool) {
+	if kind == "" {
+		p.fields[field] = keep
+		return
+	}
+	if p.kinds[kind] == nil {
+		p.kinds[kind] = map[string]bool{}
+	}
+	p.kinds[kind][field] = keep
+}
+
+// SetSpec keeps or drops fields given as [Kind:]field[,field...], e.g. "finalizers" or "Job:generateName,ownerReferences"
+func (p *Policy) SetSpec(spec string, keep bool) error {
+	kind, fields, found := strings.Cut(spec, ":")
+	if !found {
+		kind, fields = "", spec
+	}
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || strings.ContainsAny(f, ".:") {
+			return fmt.Errorf("invalid metadata field '%s' in '%s', want [Kind:]field[,field...]", f, spec)
+		}
+		p.Set(strings.TrimSpace(kind), f, keep)
+	}
+	return nil
+}
+
+// keeps tells if 'field' of an object of 'kind' is kept, and whether the policy says so explicitly
+func (p *Policy) keeps(kind string, field string) (keep bool, explicit bool) {
+	if k, ok := p.kinds[kind][field]; ok {
+		return k, true
+	}
+	k, ok := p.fields[field]
+	return k, ok
+}
+
+// Apply rebuilds the metadata of the json object 'in' with the fields the policy keeps
+func (p *Policy) Apply(in string) (string, error) {
+	meta := gjson.Get(in, "metadata")
+	if !meta.IsObject() {
+		return in, nil
+	}
+	kind := gjson.Get(in, "kind").String()
+	generatedBy, generated := Generated(in)
+
+	kept := map[string]string{}
+	var others []string
+	meta.ForEach(func(key, value gjson.Result) bool {
+		field := key.String()
+		keep, explicit := p.keeps(kind, field)
+		if field == "generateName" && !explicit && meta.Get("name").String() == "" {
+			keep = true
+		}
+		if keep {
+			kept[field] = value.Raw
+			others = append(others, field)
+		}
+		return true
+	})
+
+	out := "{}"
+	var err error
+	for _, field := range append(fieldOrder, others...) {
+		raw, ok := kept[field]
+		if !ok {
+			continue
+		}
+		delete(kept, field)
+		out, err = sjson.SetRaw(out, paths.Escape(field), raw)
+		if err != nil {
+			return in, fmt.Errorf("error setting metadata.%s : %v", field, err)
+		}
+	}
+	if p.FlagGenerated && generated {
+		out, err = sjson.Set(out, "annotations."+paths.Escape(GeneratedAnnotation), generatedBy)
+		if err != nil {
+			return in, fmt.Errorf("error flagging generated object : %v", err)
+		}
+	}
+	in, err = sjson.SetRaw(in, "metadata", out)
+	if err != nil {
+		return in, fmt.Errorf("error setting new metadata : %v", err)
+	}
+	return in, nil
+}
+
+// Generated returns the controller owner of the json object 'in' as Kind/name, if it has one
+func Generated(in string) (string, bool) {
+	for _, ref := range gjson.Get(in, "metadata.ownerReferences").Array() {
+		if ref.Get("controller").Bool() {
+			return ref.Get("kind").String() + "/" + ref.Get("name").String(), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,102 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+)
+
+const pod = `{"apiVersion": "v1", "kind": "Pod", "metadata": {
+	"name": "web-abc", "generateName": "web-", "namespace": "app", "uid": "1", "resourceVersion": "2", "creationTimestamp": "2024-01-01T00:00:00Z",
+	"labels": {"app": "web"}, "finalizers": ["example.com/cleanup"],
+	"ownerReferences": [{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "web-5d4f", "uid": "3", "controller": true}],
+	"managedFields": [{"manager": "kube-controller-manager"}]}}`
+
+func TestApply(t *testing.T) {
+	cases := []struct {
+		title  string
+		policy func() *Policy
+		data   string
+		expect string
+	}{
+		{
+			title:  "default",
+			policy: DefaultPolicy,
+			data:   pod,
+			expect: `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web-abc", "namespace": "app", "labels": {"app": "web"}}}`,
+		},
+		{
+			title:  "generateName without name",
+			policy: DefaultPolicy,
+			data:   `{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"generateName": "backup-", "namespace": "app", "uid": "1"}}`,
+			expect: `{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"generateName": "backup-", "namespace": "app"}}`,
+		},
+		{
+			title: "keep owner references and finalizers",
+			policy: func() *Policy {
+				p := DefaultPolicy()
+				p.Set("", "ownerReferences", true)
+				p.Set("", "finalizers", true)
+				return p
+			},
+			data: pod,
+			expect: `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web-abc", "namespace": "app", "labels": {"app": "web"},
+				"ownerReferences": [{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "web-5d4f", "uid": "3", "controller": true}],
+				"finalizers": ["example.com/cleanup"]}}`,
+		},
+		{
+			title: "per kind",
+			policy: func() *Policy {
+				p := DefaultPolicy()
+				p.SetSpec("Pod:generateName,uid", true)
+				p.SetSpec("Pod:labels", false)
+				p.SetSpec("Job:finalizers", true)
+				return p
+			},
+			data:   pod,
+			expect: `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web-abc", "generateName": "web-", "namespace": "app", "uid": "1"}}`,
+		},
+		{
+			title: "flag generated",
+			policy: func() *Policy {
+				p := DefaultPolicy()
+				p.FlagGenerated = true
+				return p
+			},
+			data:   pod,
+			expect: `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web-abc", "namespace": "app", "labels": {"app": "web"}, "annotations": {"kubectl-neatx.io/generated-by": "ReplicaSet/web-5d4f"}}}`,
+		},
+		{
+			title: "not generated",
+			policy: func() *Policy {
+				p := DefaultPolicy()
+				p.FlagGenerated = true
+				return p
+			},
+			data:   `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c", "ownerReferences": [{"kind": "Foo", "name": "f"}]}}`,
+			expect: `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c"}}`,
+		},
+	}
+	for _, c := range cases {
+		res, err := c.policy().Apply(c.data)
+		if err != nil {
+			t.Errorf("test case '%s' failed. error: %v", c.title, err)
+			continue
+		}
+		equal, err := testutil.JSONEqual(res, c.expect)
+		if err != nil {
+			t.Errorf("error comparing json: %v", err)
+		}
+		if !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, res)
+		}
+	}
+}
+
+func TestSetSpec(t *testing.T) {
+	for _, invalid := range []string{"", "Pod:", "metadata.uid", "Pod:a:b"} {
+		if err := DefaultPolicy().SetSpec(invalid, true); err == nil {
+			t.Errorf("test case '%s' failed. want an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package order sorts objects so that each one comes after the objects it depends on,
+// which is the order they can be created in.
+package order
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// tiers give the order of kinds when no explicit reference says otherwise. kinds not listed,
+// e.g. custom resources, come last
+var tiers = map[string]int{
+	"Namespace": 0,
+
+	"CustomResourceDefinition": 1,
+
+	"ServiceAccount":     2,
+	"ClusterRole":        2,
+	"Role":               2,
+	"ClusterRoleBinding": 2,
+	"RoleBinding":        2,
+	"PriorityClass":      2,
+	"StorageClass":       2,
+	"IngressClass":       2,
+
+	"ConfigMap": 3,
+	"Secret":    3,
+
+	"PersistentVolume":      4,
+	"PersistentVolumeClaim": 4,
+
+	"Pod":                   5,
+	"ReplicationController": 5,
+	"ReplicaSet":            5,
+	"Deployment":            5,
+	"StatefulSet":           5,
+	"DaemonSet":             5,
+	"Job":                   5,
+	"CronJob":               5,
+
+	"Service": 6,
+	"Ingress": 6,
+}
+
+const lastTier = 7
+
+// podSpecs are the paths of the pod spec of workloads
+var podSpecs = map[string]string{
+	"Pod":                   "spec",
+	"ReplicationController": "spec.template.spec",
+	"ReplicaSet":            "spec.template.spec",
+	"Deployment":            "spec.template.spec",
+	"StatefulSet":           "spec.template.spec",
+	"DaemonSet":             "spec.template.spec",
+	"Job":                   "spec.template.spec",
+	"CronJob":               "spec.jobTemplate.spec.template.spec",
+}
+
+// podSpecRefs are the references of a pod spec to namespaced objects, by kind
+var podSpecRefs = map[string][]string{
+	"ServiceAccount": {"serviceAccountName", "serviceAccount"},
+	"Secret": {
+		"imagePullSecrets.#.name",
+		"volumes.#.secret.secretName",
+		"volumes.#.projected.sources.#.secret.name",
+		"containers.#.envFrom.#.secretRef.name",
+		"containers.#.env.#.valueFrom.secretKeyRef.name",
+		"initContainers.#.envFrom.#.secretRef.name",
+		"initContainers.#.env.#.valueFrom.secretKeyRef.name",
+	},
+	"ConfigMap": {
+		"volumes.#.configMap.name",
+		"volumes.#.projected.sources.#.configMap.name",
+		"containers.#.envFrom.#.configMapRef.name",
+		"containers.#.env.#.valueFrom.configMapKeyRef.name",
+		"initContainers.#.envFrom.#.configMapRef.name",
+		"initContainers.#.env.#.valueFrom.configMapKeyRef.name",
+	},
+	"PersistentVolumeClaim": {"volumes.#.persistentVolumeClaim.claimName"},
+}
+
+// key identifies an object by kind, namespace and name
+type key struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+type node struct {
+	index int
+	tier  int
+	deps  []int
+}
+
+// Sort returns the indexes of the json 'objects' in dependency order: namespaces, CRDs, RBAC and service accounts,
+// config maps and secrets, volumes, workloads, then services and ingresses. explicit references, e.g. from a pod spec
+// to a config map or from a custom resource to its CRD, always come first. otherwise the original order is kept.
+// objects caught in a reference cycle keep their tier order.
+func Sort(objects []string) []int {
+	nodes := make([]*node, len(objects))
+	byKey := map[key]int{}
+	crds := map[string]int{}
+	for i, obj := range objects {
+		kind := gjson.Get(obj, "kind").String()
+		tier, ok := tiers[kind]
+		if !ok {
+			tier = lastTier
+		}
+		nodes[i] = &node{index: i, tier: tier}
+		byKey[keyOf(obj)] = i
+		if kind == "CustomResourceDefinition" {
+			crds[gjson.Get(obj, "spec.group").String()+"/"+gjson.Get(obj, "spec.names.kind").String()] = i
+		}
+	}
+	for i, obj := range objects {
+		for _, ref := range references(obj) {
+			if dep, ok := byKey[ref]; ok && dep != i {
+				nodes[i].deps = append(nodes[i].deps, dep)
+			}
+		}
+		group := strings.Split(gjson.Get(obj, "apiVersion").String(), "/")[0]
+		if dep, ok := crds[group+"/"+gjson.Get(obj, "kind").String()]; ok && dep != i {
+			nodes[i].deps = append(nodes[i].deps, dep)
+		}
+	}
+
+	// Kahn's algorithm, picking the ready node of lowest tier then index
+	dependents := make([][]int, len(nodes))
+	pending := make([]int, len(nodes))
+	for _, n := range nodes {
+		for _, d := range n.deps {
+			dependents[d] = append(dependents[d], n.index)
+			pending[n.index]++
+		}
+	}
+	less := func(a, b int) bool {
+		if nodes[a].tier != nodes[b].tier {
+			return nodes[a].tier < nodes[b].tier
+		}
+		return a < b
+	}
+	done := make([]bool, len(nodes))
+	var res []int
+	for len(res) < len(nodes) {
+		next := -1
+		for i := range nodes {
+			if !done[i] && pending[i] == 0 && (next == -1 || less(i, next)) {
+				next = i
+			}
+		}
+		if next == -1 {
+			// a cycle, break it at its lowest node
+			for i := range nodes {
+				if !done[i] && (next == -1 || less(i, next)) {
+					next = i
+				}
+			}
+		}
+		done[next] = true
+		res = append(res, next)
+		for _, d := range dependents[next] {
+			pending[d]--
+		}
+	}
+	return res
+}
+
+// SortStrings returns 'objects' in dependency order, see Sort
+func SortStrings(objects []string) []string {
+	res := make([]string, 0, len(objects))
+	for _, i := range Sort(objects) {
+		res = append(res, objects[i])
+	}
+	return res
+}
+
+func keyOf(obj string) key {
+	return key{
+		kind:      gjson.Get(obj, "kind").String(),
+		namespace: gjson.Get(obj, "metadata.namespace").String(),
+		name:      gjson.Get(obj, "metadata.name").String(),
+	}
+}
+
+// references returns the objects 'obj' refers to
+func references(obj string) []key {
+	kind := gjson.Get(obj, "kind").String()
+	ns := gjson.Get(obj, "metadata.namespace").String()
+	var res []key
+	if ns != "" {
+		res = append(res, key{kind: "Namespace", name: ns})
+	}
+	if spec, ok := podSpecs[kind]; ok {
+		podSpec := gjson.Get(obj, spec).Raw
+		for refKind, paths := range podSpecRefs {
+			for _, p := range paths {
+				for _, name := range values(podSpec, p) {
+					res = append(res, key{kind: refKind, namespace: ns, name: name})
+				}
+			}
+		}
+		if pc := gjson.Get(podSpec, "priorityClassName").String(); pc != "" {
+			res = append(res, key{kind: "PriorityClass", name: pc})
+		}
+	}
+	switch kind {
+	case "RoleBinding", "ClusterRoleBinding":
+		roleKind := gjson.Get(obj, "roleRef.kind").String()
+		roleNs := ""
+		if roleKind == "Role" {
+			roleNs = ns
+		}
+		res = append(res, key{kind: roleKind, namespace: roleNs, name: gjson.Get(obj, "roleRef.name").String()})
+		for _, s := range gjson.Get(obj, "subjects").Array() {
+			if s.Get("kind").String() == "ServiceAccount" {
+				res = append(res, key{kind: "ServiceAccount", namespace: s.Get("namespace").String(), name: s.Get("name").String()})
+			}
+		}
+	case "PersistentVolumeClaim":
+		res = append(res, key{kind: "PersistentVolume", name: gjson.Get(obj, "spec.volumeName").String()})
+		res = append(res, key{kind: "StorageClass", name: gjson.Get(obj, "spec.storageClassName").String()})
+	case "PersistentVolume":
+		res = append(res, key{kind: "StorageClass", name: gjson.Get(obj, "spec.storageClassName").String()})
+	case "StatefulSet":
+		for _, sc := range values(obj, "spec.volumeClaimTemplates.#.spec.storageClassName") {
+			res = append(res, key{kind: "StorageClass", name: sc})
+		}
+	case "Ingress":
+		for _, p := range []string{"spec.defaultBackend.service.name", "spec.rules.#.http.paths.#.backend.service.name"} {
+			for _, name := range values(obj, p) {
+				res = append(res, key{kind: "Service", namespace: ns, name: name})
+			}
+		}
+		for _, name := range values(obj, "spec.tls.#.secretName") {
+			res = append(res, key{kind: "Secret", namespace: ns, name: name})
+		}
+		res = append(res, key{kind: "IngressClass", name: gjson.Get(obj, "spec.ingressClassName").String()})
+	case "HorizontalPodAutoscaler":
+		res = append(res, key{kind: gjson.Get(obj, "spec.scaleTargetRef.kind").String(), namespace: ns, name: gjson.Get(obj, "spec.scaleTargetRef.name").String()})
+	}
+	return res
+}
+
+// values returns the non empty strings at a gjson path, flattening the arrays of `#` segments
+func values(json string, path string) []string {
+	var res []string
+	var flatten func(r gjson.Result)
+	flatten = func(r gjson.Result) {
+		if r.IsArray() {
+			for _, e := range r.Array() {
+				flatten(e)
+			}
+		} else if s := r.String(); s != "" {
+			res = append(res, s)
+		}
+	}
+	flatten(gjson.Get(json, path))
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,91 @@
+package order
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func obj(apiVersion, kind, ns, name, rest string) string {
+	if rest != "" {
+		rest = ", " + rest
+	}
+	return fmt.Sprintf(`{"apiVersion": "%s", "kind": "%s", "metadata": {"name": "%s", "namespace": "%s"}%s}`, apiVersion, kind, name, ns, rest)
+}
+
+// names describes objects as kind/name
+func names(objects []string) []string {
+	var res []string
+	for _, o := range objects {
+		k := keyOf(o)
+		res = append(res, k.kind+"/"+k.name)
+	}
+	return res
+}
+
+func TestSort(t *testing.T) {
+	deploy := obj("apps/v1", "Deployment", "app", "web", `"spec": {"template": {"spec": {
+		"serviceAccountName": "web",
+		"containers": [{"name": "web", "envFrom": [{"configMapRef": {"name": "web-env"}}], "env": [{"name": "PW", "valueFrom": {"secretKeyRef": {"name": "db", "key": "pw"}}}]}],
+		"volumes": [{"name": "data", "persistentVolumeClaim": {"claimName": "data"}}]}}}`)
+	service := obj("v1", "Service", "app", "web", "")
+	ingress := obj("networking.k8s.io/v1", "Ingress", "app", "web", `"spec": {"rules": [{"http": {"paths": [{"backend": {"service": {"name": "web"}}}]}}]}`)
+	cm := obj("v1", "ConfigMap", "app", "web-env", "")
+	secret := obj("v1", "Secret", "app", "db", "")
+	pvc := obj("v1", "PersistentVolumeClaim", "app", "data", "")
+	sa := obj("v1", "ServiceAccount", "app", "web", "")
+	rb := obj("rbac.authorization.k8s.io/v1", "RoleBinding", "app", "web", `"roleRef": {"kind": "Role", "name": "web"}, "subjects": [{"kind": "ServiceAccount", "name": "web", "namespace": "app"}]`)
+	role := obj("rbac.authorization.k8s.io/v1", "Role", "app", "web", "")
+	ns := `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "app"}}`
+	crd := `{"apiVersion": "apiextensions.k8s.io/v1", "kind": "CustomResourceDefinition", "metadata": {"name": "widgets.example.com"}, "spec": {"group": "example.com", "names": {"kind": "Widget", "plural": "widgets"}}}`
+	widget := obj("example.com/v1", "Widget", "app", "w", "")
+	other := obj("example.org/v1", "Gadget", "app", "g", "")
+	hpaA := obj("autoscaling/v2", "HorizontalPodAutoscaler", "app", "a", `"spec": {"scaleTargetRef": {"kind": "HorizontalPodAutoscaler", "name": "b"}}`)
+	hpaB := obj("autoscaling/v2", "HorizontalPodAutoscaler", "app", "b", `"spec": {"scaleTargetRef": {"kind": "HorizontalPodAutoscaler", "name": "a"}}`)
+
+	cases := []struct {
+		title   string
+		objects []string
+		expect  []string
+	}{
+		{
+			title:   "tiers",
+			objects: []string{ingress, service, deploy, pvc, secret, cm, rb, role, sa, widget, crd, ns},
+			expect:  []string{ns, crd, role, sa, rb, secret, cm, pvc, deploy, service, ingress, widget},
+		},
+		{
+			title:   "original order within a tier",
+			objects: []string{secret, cm, other, widget},
+			expect:  []string{secret, cm, other, widget},
+		},
+		{
+			title:   "explicit references first",
+			objects: []string{widget, obj("v1", "ConfigMap", "app", "c", ""), crd, obj("v1", "Gadget", "app", "g", `"spec": {"scaleTargetRef": {}}`)},
+			expect:  []string{crd, obj("v1", "ConfigMap", "app", "c", ""), widget, obj("v1", "Gadget", "app", "g", `"spec": {"scaleTargetRef": {}}`)},
+		},
+		{
+			title:   "cycle",
+			objects: []string{cm, hpaA, hpaB},
+			expect:  []string{cm, hpaA, hpaB},
+		},
+	}
+	for _, c := range cases {
+		have := names(SortStrings(c.objects))
+		if !reflect.DeepEqual(have, names(c.expect)) {
+			t.Errorf("test case '%s' failed. want: '%v' have: '%v'", c.title, names(c.expect), have)
+		}
+	}
+}
+
+func TestSortReferenceAgainstTier(t *testing.T) {
+	// an HPA has no tier, but a ConfigMap is not expected to depend on anything
+	hpa := obj("autoscaling/v2", "HorizontalPodAutoscaler", "app", "web", `"spec": {"scaleTargetRef": {"kind": "Deployment", "name": "web"}}`)
+	deploy := obj("apps/v1", "Deployment", "app", "web", "")
+	pod := obj("v1", "Pod", "app", "p", `"spec": {"priorityClassName": "high"}`)
+	pc := obj("scheduling.k8s.io/v1", "PriorityClass", "", "high", "")
+	objects := []string{hpa, pod, deploy, pc}
+	want := []string{pc, pod, deploy, hpa}
+	if have := names(SortStrings(objects)); !reflect.DeepEqual(have, names(want)) {
+		t.Errorf("want: '%v' have: '%v'", names(want), have)
+	}
+}
@@ -0,0 +1,26 @@
+// Package paths builds gjson and sjson paths out of json keys.
+package paths
+
+import "strings"
+
+// Escape makes a json key safe to use as a gjson or sjson path segment, by escaping the characters they treat specially
+func Escape(key string) string {
+	var b strings.Builder
+	for _, c := range key {
+		switch c {
+		case '.', '*', '?', '|', '#', '@', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Join builds a path out of keys, escaping each of them
+func Join(keys ...string) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = Escape(k)
+	}
+	return strings.Join(parts, ".")
+}
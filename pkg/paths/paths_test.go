@@ -0,0 +1,39 @@
+package paths
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+func TestEscape(t *testing.T) {
+	cases := []struct {
+		key    string
+		expect string
+	}{
+		{"name", "name"},
+		{"kubectl.kubernetes.io/last-applied-configuration", `kubectl\.kubernetes\.io/last-applied-configuration`},
+		{`a*b?c|d#e@f`, `a\*b\?c\|d\#e\@f`},
+		{`back\slash`, `back\\slash`},
+	}
+	for _, c := range cases {
+		if res := Escape(c.key); res != c.expect {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.key, c.expect, res)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	keys := []string{"metadata", "annotations", `example.com/a\b*`}
+	json, err := sjson.Set(`{}`, Join(keys...), "v")
+	if err != nil {
+		t.Fatalf("error setting %v: %v", keys, err)
+	}
+	if json != `{"metadata":{"annotations":{"example.com/a\\b*":"v"}}}` {
+		t.Errorf("want the key set as it is, have: '%s'", json)
+	}
+	if res := gjson.Get(json, Join(keys...)).String(); res != "v" {
+		t.Errorf("want: 'v' have: '%s'", res)
+	}
+}
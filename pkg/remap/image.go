@@ -0,0 +1,180 @@
+package remap
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/rules"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ImageMap rewrites container images, e.g. to pull them from a mirror. the first matching rule wins
+type ImageMap []imageRule
+
+// imageRule replaces an image prefix, or the matches of a regular expression
+type imageRule struct {
+	prefix      string
+	re          *regexp.Regexp
+	replacement string
+}
+
+// ImageRewrite is an image an ImageMap rewrote
+type ImageRewrite struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Container string
+	From      string
+	To        string
+}
+
+func (r ImageRewrite) String() string {
+	name := r.Name
+	if r.Namespace != "" {
+		name = r.Namespace + "/" + r.Name
+	}
+	return fmt.Sprintf("%s %s container %s: %s -> %s", r.Kind, name, r.Container, r.From, r.To)
+}
+
+// imagePodSpecs are the paths of the pod specs of the kinds that have one
+var imagePodSpecs = map[string]string{
+	"Pod":                   "spec",
+	"PodTemplate":           "template.spec",
+	"ReplicationController": "spec.template.spec",
+	"ReplicaSet":            "spec.template.spec",
+	"Deployment":            "spec.template.spec",
+	"StatefulSet":           "spec.template.spec",
+	"DaemonSet":             "spec.template.spec",
+	"Job":                   "spec.template.spec",
+	"CronJob":               "spec.jobTemplate.spec.template.spec",
+}
+
+var containerFields = []string{"initContainers", "containers", "ephemeralContainers"}
+
+// ParseImageMap parses image rules: src=dst replaces the image prefix src with dst, and regex:expr=replacement
+// replaces the matches of expr, with $1 style references to its groups
+func ParseImageMap(specs []string) (ImageMap, error) {
+	var m ImageMap
+	for _, s := range specs {
+		if err := m.add(s); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// LoadImageMapFile reads image rules from a file, one per line. empty lines and lines starting with # are ignored
+func LoadImageMapFile(path string) (ImageMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m ImageMap
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := m.add(text); err != nil {
+			return nil, fmt.Errorf("error in %s line %d : %v", path, line, err)
+		}
+	}
+	return m, scanner.Err()
+}
+
+func (m *ImageMap) add(spec string) error {
+	if expr, ok := strings.CutPrefix(spec, "regex:"); ok {
+		// the replacement comes after the last =, the expression may hold some
+		i := strings.LastIndex(expr, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid image rule '%s', want regex:expr=replacement", spec)
+		}
+		re, err := regexp.Compile(expr[:i])
+		if err != nil {
+			return fmt.Errorf("invalid image rule '%s' : %v", spec, err)
+		}
+		*m = append(*m, imageRule{re: re, replacement: expr[i+1:]})
+		return nil
+	}
+	src, dst, ok := strings.Cut(spec, "=")
+	src, dst = strings.TrimSpace(src), strings.TrimSpace(dst)
+	if !ok || src == "" {
+		return fmt.Errorf("invalid image rule '%s', want src=dst or regex:expr=replacement", spec)
+	}
+	*m = append(*m, imageRule{prefix: src, replacement: dst})
+	return nil
+}
+
+// Rewrite returns the rewritten image. rules match the image as written, or its fully qualified name,
+// e.g. docker.io/library/nginx:1.25 for nginx:1.25, so docker.io/ rules catch Docker Hub images without a registry
+func (m ImageMap) Rewrite(image string) (string, bool) {
+	candidates := []string{image}
+	if full := qualifiedImage(image); full != image {
+		candidates = append(candidates, full)
+	}
+	for _, r := range m {
+		for _, c := range candidates {
+			if r.re != nil {
+				if r.re.MatchString(c) {
+					return r.re.ReplaceAllString(c, r.replacement), true
+				}
+			} else if strings.HasPrefix(c, r.prefix) {
+				return r.replacement + strings.TrimPrefix(c, r.prefix), true
+			}
+		}
+	}
+	return image, false
+}
+
+// qualifiedImage adds the implicit docker.io registry and library/ repository to an image reference
+func qualifiedImage(image string) string {
+	first, _, hasSlash := strings.Cut(image, "/")
+	if hasSlash && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return image
+	}
+	if !hasSlash {
+		return "docker.io/library/" + image
+	}
+	return "docker.io/" + image
+}
+
+// Apply rewrites the images of the containers, init containers and ephemeral containers of the pod spec of the json object 'in'
+func (m ImageMap) Apply(in string) (string, []ImageRewrite, error) {
+	spec, ok := imagePodSpecs[gjson.Get(in, "kind").String()]
+	if len(m) == 0 || !ok {
+		return in, nil, nil
+	}
+	var res []ImageRewrite
+	for _, field := range containerFields {
+		for _, p := range rules.Expand(in, spec+"."+field+".#") {
+			image := gjson.Get(in, p+".image").String()
+			if image == "" {
+				continue
+			}
+			rewritten, ok := m.Rewrite(image)
+			if !ok || rewritten == image {
+				continue
+			}
+			var err error
+			in, err = sjson.Set(in, p+".image", rewritten)
+			if err != nil {
+				return "", nil, fmt.Errorf("error rewriting image at '%s' : %v", p, err)
+			}
+			res = append(res, ImageRewrite{
+				Kind:      gjson.Get(in, "kind").String(),
+				Namespace: gjson.Get(in, "metadata.namespace").String(),
+				Name:      gjson.Get(in, "metadata.name").String(),
+				Container: gjson.Get(in, p+".name").String(),
+				From:      image,
+				To:        rewritten,
+			})
+		}
+	}
+	return in, res, nil
+}
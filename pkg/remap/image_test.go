@@ -0,0 +1,113 @@
+package remap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+)
+
+func TestImageMapRewrite(t *testing.T) {
+	m, err := ParseImageMap([]string{
+		"quay.io/=registry.local/quay/",
+		`regex:^gcr\.io/([^/]+)/(.*)$=registry.local/gcr-$1/$2`,
+		"docker.io/=registry.local/dockerhub/",
+	})
+	if err != nil {
+		t.Fatalf("error parsing image map: %v", err)
+	}
+	cases := []struct {
+		image  string
+		expect string
+		ok     bool
+	}{
+		{"nginx:1.25", "registry.local/dockerhub/library/nginx:1.25", true},
+		{"bitnami/redis:7", "registry.local/dockerhub/bitnami/redis:7", true},
+		{"docker.io/library/busybox", "registry.local/dockerhub/library/busybox", true},
+		{"quay.io/prometheus/node-exporter:v1.7.0", "registry.local/quay/prometheus/node-exporter:v1.7.0", true},
+		{"gcr.io/distroless/static@sha256:abcd", "registry.local/gcr-distroless/static@sha256:abcd", true},
+		{"registry.k8s.io/pause:3.9", "registry.k8s.io/pause:3.9", false},
+		{"localhost:5000/app", "localhost:5000/app", false},
+	}
+	for _, c := range cases {
+		res, ok := m.Rewrite(c.image)
+		if res != c.expect || ok != c.ok {
+			t.Errorf("test case '%s' failed. want: '%s' %v have: '%s' %v", c.image, c.expect, c.ok, res, ok)
+		}
+	}
+}
+
+func TestImageMapApply(t *testing.T) {
+	m, _ := ParseImageMap([]string{"docker.io/=registry.local/dockerhub/"})
+	cases := []struct {
+		title    string
+		data     string
+		expect   string
+		rewrites int
+	}{
+		{
+			title:    "deployment",
+			data:     `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "app1"}, "spec": {"template": {"spec": {"initContainers": [{"name": "init", "image": "busybox"}], "containers": [{"name": "web", "image": "nginx:1.25"}, {"name": "proxy", "image": "quay.io/envoy:v1"}]}}}}`,
+			expect:   `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "app1"}, "spec": {"template": {"spec": {"initContainers": [{"name": "init", "image": "registry.local/dockerhub/library/busybox"}], "containers": [{"name": "web", "image": "registry.local/dockerhub/library/nginx:1.25"}, {"name": "proxy", "image": "quay.io/envoy:v1"}]}}}}`,
+			rewrites: 2,
+		},
+		{
+			title:    "cronjob",
+			data:     `{"apiVersion": "batch/v1", "kind": "CronJob", "metadata": {"name": "backup"}, "spec": {"jobTemplate": {"spec": {"template": {"spec": {"containers": [{"name": "backup", "image": "alpine"}]}}}}}}`,
+			expect:   `{"apiVersion": "batch/v1", "kind": "CronJob", "metadata": {"name": "backup"}, "spec": {"jobTemplate": {"spec": {"template": {"spec": {"containers": [{"name": "backup", "image": "registry.local/dockerhub/library/alpine"}]}}}}}}`,
+			rewrites: 1,
+		},
+		{
+			title:    "pod ephemeral containers",
+			data:     `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "p"}, "spec": {"containers": [{"name": "app"}], "ephemeralContainers": [{"name": "debug", "image": "busybox"}]}}`,
+			expect:   `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "p"}, "spec": {"containers": [{"name": "app"}], "ephemeralContainers": [{"name": "debug", "image": "registry.local/dockerhub/library/busybox"}]}}`,
+			rewrites: 1,
+		},
+		{
+			title:  "not a workload",
+			data:   `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c"}, "data": {"image": "nginx"}}`,
+			expect: `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c"}, "data": {"image": "nginx"}}`,
+		},
+	}
+	for _, c := range cases {
+		res, rewrites, err := m.Apply(c.data)
+		if err != nil {
+			t.Errorf("error in Apply for case '%s': %v", c.title, err)
+			continue
+		}
+		equal, err := testutil.JSONEqual(res, c.expect)
+		if err != nil || !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, res)
+		}
+		if len(rewrites) != c.rewrites {
+			t.Errorf("test case '%s' failed. want %d rewrites have: '%v'", c.title, c.rewrites, rewrites)
+		}
+	}
+
+	_, rewrites, _ := m.Apply(cases[0].data)
+	want := "Deployment app1/web container init: busybox -> registry.local/dockerhub/library/busybox"
+	if len(rewrites) == 0 || rewrites[0].String() != want {
+		t.Errorf("want: '%s' have: '%v'", want, rewrites)
+	}
+}
+
+func TestLoadImageMapFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "images.txt")
+	os.WriteFile(path, []byte("# mirrors\ndocker.io/=registry.local/dockerhub/\n\nregex:^ghcr\\.io/(.*)=registry.local/ghcr/$1\n"), 0644)
+	m, err := LoadImageMapFile(path)
+	if err != nil {
+		t.Fatalf("error loading image map: %v", err)
+	}
+	if res, _ := m.Rewrite("ghcr.io/org/app:1"); res != "registry.local/ghcr/org/app:1" {
+		t.Errorf("want: 'registry.local/ghcr/org/app:1' have: '%s'", res)
+	}
+
+	os.WriteFile(path, []byte("docker.io/\n"), 0644)
+	if _, err := LoadImageMapFile(path); err == nil {
+		t.Errorf("want error for a rule without =")
+	}
+	if _, err := ParseImageMap([]string{"regex:([=x"}); err == nil {
+		t.Errorf("want error for an invalid regular expression")
+	}
+}
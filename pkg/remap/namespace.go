@@ -0,0 +1,115 @@
+// Package remap rewrites references inside objects when they move to another environment,
+// e.g. to another namespace.
+package remap
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/rules"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// NamespaceMap maps source namespaces to target namespaces. namespaces it doesn't name are left as they are.
+type NamespaceMap map[string]string
+
+// namespaceRefs are the paths of namespace references, by kind
+var namespaceRefs = map[string][]string{
+	"RoleBinding":                    {"subjects.#.namespace"},
+	"ClusterRoleBinding":             {"subjects.#.namespace"},
+	"MutatingWebhookConfiguration":   {"webhooks.#.clientConfig.service.namespace"},
+	"ValidatingWebhookConfiguration": {"webhooks.#.clientConfig.service.namespace"},
+	"APIService":                     {"spec.service.namespace"},
+	"CustomResourceDefinition":       {"spec.conversion.webhook.clientConfig.service.namespace"},
+	"PersistentVolume":               {"spec.claimRef.namespace"},
+}
+
+// ParseNamespaceMap parses src=dst pairs
+func ParseNamespaceMap(pairs []string) (NamespaceMap, error) {
+	m := NamespaceMap{}
+	for _, p := range pairs {
+		if err := m.add(p); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// LoadNamespaceMapFile reads src=dst pairs from a file, one per line. empty lines and lines starting with # are ignored
+func LoadNamespaceMapFile(path string) (NamespaceMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := NamespaceMap{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := m.add(text); err != nil {
+			return nil, fmt.Errorf("error in %s line %d : %v", path, line, err)
+		}
+	}
+	return m, scanner.Err()
+}
+
+func (m NamespaceMap) add(pair string) error {
+	src, dst, ok := strings.Cut(pair, "=")
+	src, dst = strings.TrimSpace(src), strings.TrimSpace(dst)
+	if !ok || src == "" || dst == "" {
+		return fmt.Errorf("invalid namespace mapping '%s', want src=dst", pair)
+	}
+	if prev, ok := m[src]; ok && prev != dst {
+		return fmt.Errorf("namespace '%s' is mapped to both '%s' and '%s'", src, prev, dst)
+	}
+	m[src] = dst
+	return nil
+}
+
+// Merge returns a map with the mappings of both, 'other' wins on conflicts
+func (m NamespaceMap) Merge(other NamespaceMap) NamespaceMap {
+	res := NamespaceMap{}
+	for k, v := range m {
+		res[k] = v
+	}
+	for k, v := range other {
+		res[k] = v
+	}
+	return res
+}
+
+// Apply moves the json object 'in' to the mapped namespace: its metadata.namespace, the name of Namespace objects,
+// and the namespace references of RBAC bindings subjects, webhook and APIService services
+func (m NamespaceMap) Apply(in string) (string, error) {
+	if len(m) == 0 {
+		return in, nil
+	}
+	paths := []string{"metadata.namespace"}
+	kind := gjson.Get(in, "kind").String()
+	if kind == "Namespace" && gjson.Get(in, "apiVersion").String() == "v1" {
+		paths = append(paths, "metadata.name")
+	}
+	for _, p := range namespaceRefs[kind] {
+		paths = append(paths, rules.Expand(in, p)...)
+	}
+
+	var err error
+	for _, p := range paths {
+		ns := gjson.Get(in, p)
+		dst, ok := m[ns.String()]
+		if !ns.Exists() || !ok {
+			continue
+		}
+		in, err = sjson.Set(in, p, dst)
+		if err != nil {
+			return "", fmt.Errorf("error rewriting namespace at '%s' : %v", p, err)
+		}
+	}
+	return in, nil
+}
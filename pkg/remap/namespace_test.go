@@ -0,0 +1,98 @@
+package remap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+)
+
+func TestNamespaceMapApply(t *testing.T) {
+	m := NamespaceMap{"team-a-staging": "team-a-prod", "infra": "infra-prod"}
+	cases := []struct {
+		title  string
+		data   string
+		expect string
+	}{
+		{
+			title:  "namespaced object",
+			data:   `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c", "namespace": "team-a-staging"}}`,
+			expect: `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c", "namespace": "team-a-prod"}}`,
+		},
+		{
+			title:  "unmapped namespace",
+			data:   `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c", "namespace": "other"}}`,
+			expect: `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c", "namespace": "other"}}`,
+		},
+		{
+			title:  "namespace object",
+			data:   `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "team-a-staging"}}`,
+			expect: `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "team-a-prod"}}`,
+		},
+		{
+			title: "role binding subjects",
+			data: `{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "RoleBinding", "metadata": {"name": "rb", "namespace": "team-a-staging"},
+				"subjects": [{"kind": "ServiceAccount", "name": "app", "namespace": "team-a-staging"}, {"kind": "ServiceAccount", "name": "ci", "namespace": "infra"}, {"kind": "User", "name": "jane"}]}`,
+			expect: `{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "RoleBinding", "metadata": {"name": "rb", "namespace": "team-a-prod"},
+				"subjects": [{"kind": "ServiceAccount", "name": "app", "namespace": "team-a-prod"}, {"kind": "ServiceAccount", "name": "ci", "namespace": "infra-prod"}, {"kind": "User", "name": "jane"}]}`,
+		},
+		{
+			title:  "cluster role binding subjects",
+			data:   `{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "ClusterRoleBinding", "metadata": {"name": "crb"}, "subjects": [{"kind": "ServiceAccount", "name": "app", "namespace": "team-a-staging"}]}`,
+			expect: `{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "ClusterRoleBinding", "metadata": {"name": "crb"}, "subjects": [{"kind": "ServiceAccount", "name": "app", "namespace": "team-a-prod"}]}`,
+		},
+		{
+			title: "webhook services",
+			data: `{"apiVersion": "admissionregistration.k8s.io/v1", "kind": "ValidatingWebhookConfiguration", "metadata": {"name": "w"},
+				"webhooks": [{"name": "a.example.com", "clientConfig": {"service": {"name": "hook", "namespace": "infra"}}}, {"name": "b.example.com", "clientConfig": {"url": "https://example.com"}}]}`,
+			expect: `{"apiVersion": "admissionregistration.k8s.io/v1", "kind": "ValidatingWebhookConfiguration", "metadata": {"name": "w"},
+				"webhooks": [{"name": "a.example.com", "clientConfig": {"service": {"name": "hook", "namespace": "infra-prod"}}}, {"name": "b.example.com", "clientConfig": {"url": "https://example.com"}}]}`,
+		},
+		{
+			title:  "api service",
+			data:   `{"apiVersion": "apiregistration.k8s.io/v1", "kind": "APIService", "metadata": {"name": "v1beta1.metrics.k8s.io"}, "spec": {"service": {"name": "metrics-server", "namespace": "infra"}}}`,
+			expect: `{"apiVersion": "apiregistration.k8s.io/v1", "kind": "APIService", "metadata": {"name": "v1beta1.metrics.k8s.io"}, "spec": {"service": {"name": "metrics-server", "namespace": "infra-prod"}}}`,
+		},
+	}
+	for _, c := range cases {
+		res, err := m.Apply(c.data)
+		if err != nil {
+			t.Errorf("test case '%s' failed. error: %v", c.title, err)
+			continue
+		}
+		equal, err := testutil.JSONEqual(res, c.expect)
+		if err != nil {
+			t.Errorf("error comparing json: %v", err)
+		}
+		if !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, res)
+		}
+	}
+}
+
+func TestParseNamespaceMap(t *testing.T) {
+	m, err := ParseNamespaceMap([]string{"a=b", " c = d "})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !reflect.DeepEqual(m, NamespaceMap{"a": "b", "c": "d"}) {
+		t.Errorf("want: 'map[a:b c:d]' have: '%v'", m)
+	}
+	for _, invalid := range [][]string{{"a"}, {"=b"}, {"a="}, {"a=b", "a=c"}} {
+		if _, err := ParseNamespaceMap(invalid); err == nil {
+			t.Errorf("test case '%v' failed. want an error", invalid)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "namespaces")
+	os.WriteFile(file, []byte("# staging to prod\nteam-a-staging=team-a-prod\n\nteam-b-staging=team-b-prod\n"), 0644)
+	m, err = LoadNamespaceMapFile(file)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !reflect.DeepEqual(m, NamespaceMap{"team-a-staging": "team-a-prod", "team-b-staging": "team-b-prod"}) {
+		t.Errorf("have: '%v'", m)
+	}
+}
@@ -0,0 +1,128 @@
+package remap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/paths"
+	"github.com/Baiyuani/kubectl-neatx/pkg/rules"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// StorageMap moves persistent volumes and claims to the storage classes of another cluster
+type StorageMap struct {
+	// Classes maps source storage classes to target storage classes
+	Classes map[string]string
+	// PreserveBinding keeps claims bound to their volumes: the volume gets back a claimRef to its claim,
+	// and the claim keeps its volumeName. otherwise claims provision new volumes once classes are mapped
+	PreserveBinding bool
+}
+
+// provisioningAnnotations tie a claim to the provisioner and node of the source cluster
+var provisioningAnnotations = []string{
+	"volume.beta.kubernetes.io/storage-provisioner",
+	"volume.kubernetes.io/storage-provisioner",
+	"volume.kubernetes.io/selected-node",
+}
+
+// ParseStorageClassMap parses src=dst storage class pairs
+func ParseStorageClassMap(pairs []string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, p := range pairs {
+		src, dst, ok := strings.Cut(p, "=")
+		src, dst = strings.TrimSpace(src), strings.TrimSpace(dst)
+		if !ok || src == "" || dst == "" {
+			return nil, fmt.Errorf("invalid storage class mapping '%s', want src=dst", p)
+		}
+		if prev, ok := m[src]; ok && prev != dst {
+			return nil, fmt.Errorf("storage class '%s' is mapped to both '%s' and '%s'", src, prev, dst)
+		}
+		m[src] = dst
+	}
+	return m, nil
+}
+
+// Apply rewrites the storage classes of PersistentVolumes, PersistentVolumeClaims and StatefulSet volumeClaimTemplates
+// in the neated json object 'in'. 'original' is the object before neating, where the claimRef of volumes is read from
+func (m StorageMap) Apply(in string, original string) (string, error) {
+	if len(m.Classes) == 0 && !m.PreserveBinding {
+		return in, nil
+	}
+	var claims []string
+	switch gjson.Get(in, "kind").String() {
+	case "PersistentVolumeClaim":
+		claims = []string{""}
+	case "StatefulSet":
+		claims = rules.Expand(in, "spec.volumeClaimTemplates.#")
+	case "PersistentVolume":
+		return m.applyVolume(in, original)
+	default:
+		return in, nil
+	}
+
+	var err error
+	for _, c := range claims {
+		prefix := c
+		if prefix != "" {
+			prefix += "."
+		}
+		var mapped bool
+		if in, mapped, err = m.mapClass(in, prefix+"spec.storageClassName"); err != nil {
+			return "", err
+		}
+		if m.PreserveBinding || !mapped {
+			continue
+		}
+		// the claim provisions a new volume in the target class
+		fields := []string{prefix + "spec.volumeName"}
+		for _, a := range provisioningAnnotations {
+			fields = append(fields, prefix+"metadata.annotations."+paths.Escape(a))
+		}
+		for _, p := range fields {
+			if in, err = sjson.Delete(in, p); err != nil {
+				return "", fmt.Errorf("error deleting '%s' : %v", p, err)
+			}
+		}
+		if len(gjson.Get(in, prefix+"metadata.annotations").Map()) == 0 {
+			in, _ = sjson.Delete(in, prefix+"metadata.annotations")
+		}
+	}
+	return in, nil
+}
+
+// applyVolume maps the class of a volume and, to preserve the binding, points it back to its claim
+func (m StorageMap) applyVolume(in string, original string) (string, error) {
+	in, _, err := m.mapClass(in, "spec.storageClassName")
+	if err != nil {
+		return "", err
+	}
+	claim := gjson.Get(original, "spec.claimRef")
+	if !m.PreserveBinding || !claim.Get("name").Exists() {
+		return in, nil
+	}
+	// the uid and resourceVersion of the source claim mean nothing in the target, the namespace and name are enough to re-bind
+	ref := map[string]interface{}{
+		"kind":      "PersistentVolumeClaim",
+		"namespace": claim.Get("namespace").String(),
+		"name":      claim.Get("name").String(),
+	}
+	if in, err = sjson.Set(in, "spec.claimRef", ref); err != nil {
+		return "", fmt.Errorf("error setting claimRef : %v", err)
+	}
+	return in, nil
+}
+
+// mapClass rewrites the storage class at 'path', the boolean is true when the class changed
+func (m StorageMap) mapClass(in string, path string) (string, bool, error) {
+	class := gjson.Get(in, path)
+	dst, ok := m.Classes[class.String()]
+	if !class.Exists() || !ok || dst == class.String() {
+		return in, false, nil
+	}
+	in, err := sjson.Set(in, path, dst)
+	if err != nil {
+		return "", false, fmt.Errorf("error rewriting storage class at '%s' : %v", path, err)
+	}
+	return in, true, nil
+}
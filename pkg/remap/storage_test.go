@@ -0,0 +1,101 @@
+package remap
+
+import (
+	"testing"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+)
+
+func TestStorageMapApply(t *testing.T) {
+	classes := map[string]string{"gp2": "ceph-rbd"}
+	pvc := `{"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "data", "namespace": "app1", "annotations": {"volume.kubernetes.io/storage-provisioner": "ebs.csi.aws.com", "volume.kubernetes.io/selected-node": "node1"}},
+		"spec": {"storageClassName": "gp2", "volumeName": "pvc-123", "resources": {"requests": {"storage": "1Gi"}}}}`
+	unmapped := `{"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "logs", "namespace": "app1", "annotations": {"volume.kubernetes.io/storage-provisioner": "nfs.csi.k8s.io"}},
+		"spec": {"storageClassName": "standard", "volumeName": "pvc-456", "resources": {"requests": {"storage": "1Gi"}}}}`
+	classless := `{"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "static", "namespace": "app1"},
+		"spec": {"volumeName": "nfs-static", "resources": {"requests": {"storage": "1Gi"}}}}`
+	sts := `{"apiVersion": "apps/v1", "kind": "StatefulSet", "metadata": {"name": "db", "namespace": "app1"},
+		"spec": {"volumeClaimTemplates": [{"metadata": {"name": "data"}, "spec": {"storageClassName": "gp2"}}, {"metadata": {"name": "logs"}, "spec": {"storageClassName": "standard"}}]}}`
+	pv := `{"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pvc-123"}, "spec": {"storageClassName": "gp2", "capacity": {"storage": "1Gi"}}}`
+	pvOriginal := `{"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pvc-123", "uid": "1"},
+		"spec": {"storageClassName": "gp2", "capacity": {"storage": "1Gi"}, "claimRef": {"apiVersion": "v1", "kind": "PersistentVolumeClaim", "namespace": "app1", "name": "data", "uid": "2", "resourceVersion": "3"}}}`
+	cases := []struct {
+		title    string
+		m        StorageMap
+		data     string
+		original string
+		expect   string
+	}{
+		{
+			title:  "no mapping",
+			data:   pvc,
+			expect: pvc,
+		},
+		{
+			title: "claim provisions a new volume",
+			m:     StorageMap{Classes: classes},
+			data:  pvc,
+			expect: `{"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "data", "namespace": "app1"},
+				"spec": {"storageClassName": "ceph-rbd", "resources": {"requests": {"storage": "1Gi"}}}}`,
+		},
+		{
+			title:  "claim of an unmapped class keeps its volume",
+			m:      StorageMap{Classes: classes},
+			data:   unmapped,
+			expect: unmapped,
+		},
+		{
+			title:  "claim without a class keeps its volume",
+			m:      StorageMap{Classes: classes},
+			data:   classless,
+			expect: classless,
+		},
+		{
+			title: "claim keeps its volume",
+			m:     StorageMap{Classes: classes, PreserveBinding: true},
+			data:  pvc,
+			expect: `{"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "data", "namespace": "app1", "annotations": {"volume.kubernetes.io/storage-provisioner": "ebs.csi.aws.com", "volume.kubernetes.io/selected-node": "node1"}},
+				"spec": {"storageClassName": "ceph-rbd", "volumeName": "pvc-123", "resources": {"requests": {"storage": "1Gi"}}}}`,
+		},
+		{
+			title: "statefulset claim templates",
+			m:     StorageMap{Classes: classes},
+			data:  sts,
+			expect: `{"apiVersion": "apps/v1", "kind": "StatefulSet", "metadata": {"name": "db", "namespace": "app1"},
+				"spec": {"volumeClaimTemplates": [{"metadata": {"name": "data"}, "spec": {"storageClassName": "ceph-rbd"}}, {"metadata": {"name": "logs"}, "spec": {"storageClassName": "standard"}}]}}`,
+		},
+		{
+			title:    "volume",
+			m:        StorageMap{Classes: classes},
+			data:     pv,
+			original: pvOriginal,
+			expect:   `{"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pvc-123"}, "spec": {"storageClassName": "ceph-rbd", "capacity": {"storage": "1Gi"}}}`,
+		},
+		{
+			title:    "volume bound to its claim",
+			m:        StorageMap{Classes: classes, PreserveBinding: true},
+			data:     pv,
+			original: pvOriginal,
+			expect: `{"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pvc-123"},
+				"spec": {"storageClassName": "ceph-rbd", "capacity": {"storage": "1Gi"}, "claimRef": {"kind": "PersistentVolumeClaim", "namespace": "app1", "name": "data"}}}`,
+		},
+	}
+	for _, c := range cases {
+		res, err := c.m.Apply(c.data, c.original)
+		if err != nil {
+			t.Errorf("error in Apply for case '%s': %v", c.title, err)
+			continue
+		}
+		equal, err := testutil.JSONEqual(res, c.expect)
+		if err != nil || !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, res)
+		}
+	}
+
+	if _, err := ParseStorageClassMap([]string{"gp2=a", "gp2=b"}); err == nil {
+		t.Errorf("want error for a class mapped twice")
+	}
+	if _, err := ParseStorageClassMap([]string{"gp2"}); err == nil {
+		t.Errorf("want error for a pair without =")
+	}
+}
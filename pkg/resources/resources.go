@@ -0,0 +1,303 @@
+// Package resources resolves what users type on the command line (pods, po, pod, Pod, deployments.apps,
+// deployments.v1.apps) to API resources, using discovery or an embedded offline list
+package resources
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// Resource is an API resource, together with its names and scope
+type Resource struct {
+	schema.GroupVersionResource
+	Kind         string
+	SingularName string
+	ShortNames   []string
+	Namespaced   bool
+	// Verbs is nil when unknown, e.g. for the offline list
+	Verbs []string
+}
+
+// GroupVersionKind returns the GVK of the objects of the resource
+func (r Resource) GroupVersionKind() schema.GroupVersionKind {
+	return r.GroupVersion().WithKind(r.Kind)
+}
+
+// HasVerbs tells if the resource supports all of 'verbs'. resources with unknown verbs are assumed to
+func (r Resource) HasVerbs(verbs ...string) bool {
+	if r.Verbs == nil {
+		return true
+	}
+	for _, v := range verbs {
+		found := false
+		for _, rv := range r.Verbs {
+			if rv == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the resource the way kubectl names it, e.g. deployments.apps
+func (r Resource) String() string {
+	return r.GroupResource().String()
+}
+
+// Resolver maps user input to resources
+type Resolver struct {
+	// resources are ordered by preference: groups in server order, preferred version first
+	resources []Resource
+}
+
+// NewResolver returns a resolver of 'resources', which are ordered by preference
+func NewResolver(resources []Resource) *Resolver {
+	return &Resolver{resources: resources}
+}
+
+//go:embed api-resources.txt
+var builtinAPIResources []byte
+
+// Builtin returns a resolver of the built-in resources of a recent Kubernetes, for when discovery isn't available
+func Builtin() *Resolver {
+	res, err := ParseAPIResources(bytes.NewReader(builtinAPIResources))
+	if err != nil {
+		panic(fmt.Sprintf("error parsing the built-in api resources : %v", err))
+	}
+	return NewResolver(res)
+}
+
+// FromDiscovery returns a resolver of the resources the server serves. groups that fail discovery,
+// e.g. an aggregated API that is down, are left out with a warning
+func FromDiscovery(d discovery.DiscoveryInterface) (*Resolver, error) {
+	groups, lists, err := d.ServerGroupsAndResources()
+	if err != nil {
+		var groupErr *discovery.ErrGroupDiscoveryFailed
+		if !errors.As(err, &groupErr) {
+			return nil, err
+		}
+		log.Warnf("some API groups couldn't be discovered : %v", err)
+	}
+	byGroupVersion := map[string]*metav1.APIResourceList{}
+	for _, l := range lists {
+		byGroupVersion[l.GroupVersion] = l
+	}
+
+	var res []Resource
+	for _, g := range groups {
+		versions := []string{g.PreferredVersion.GroupVersion}
+		for _, v := range g.Versions {
+			if v.GroupVersion != g.PreferredVersion.GroupVersion {
+				versions = append(versions, v.GroupVersion)
+			}
+		}
+		for _, v := range versions {
+			l, ok := byGroupVersion[v]
+			if !ok {
+				continue
+			}
+			gv, err := schema.ParseGroupVersion(l.GroupVersion)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range l.APIResources {
+				if strings.Contains(r.Name, "/") {
+					// subresource
+					continue
+				}
+				res = append(res, Resource{
+					GroupVersionResource: gv.WithResource(r.Name),
+					Kind:                 r.Kind,
+					SingularName:         r.SingularName,
+					ShortNames:           r.ShortNames,
+					Namespaced:           r.Namespaced,
+					Verbs:                append([]string{}, r.Verbs...),
+				})
+			}
+		}
+	}
+	return NewResolver(res), nil
+}
+
+// ParseAPIResources parses the output of `kubectl api-resources`, with or without headers, and with the
+// VERBS column of `-o wide` if present
+func ParseAPIResources(r io.Reader) ([]Resource, error) {
+	var res []Resource
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		var verbs []string
+		if i := strings.Index(text, "["); i >= 0 {
+			verbs = strings.Fields(strings.Trim(text[i:], "[] \t"))
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 || fields[0] == "NAME" {
+			continue
+		}
+		var shortNames []string
+		switch len(fields) {
+		case 4:
+		case 5:
+			shortNames = strings.Split(fields[1], ",")
+			fields = append(fields[:1], fields[2:]...)
+		default:
+			return nil, fmt.Errorf("line %d: want NAME [SHORTNAMES] APIVERSION NAMESPACED KIND, got '%s'", line, text)
+		}
+		gv, err := schema.ParseGroupVersion(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		namespaced, err := strconv.ParseBool(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		res = append(res, Resource{
+			GroupVersionResource: gv.WithResource(fields[0]),
+			Kind:                 fields[3],
+			SingularName:         strings.ToLower(fields[3]),
+			ShortNames:           shortNames,
+			Namespaced:           namespaced,
+			Verbs:                verbs,
+		})
+	}
+	return res, scanner.Err()
+}
+
+// Resources returns all the resources, preferred versions first
+func (r *Resolver) Resources() []Resource {
+	return r.resources
+}
+
+// Preferred returns the resources in their preferred version only
+func (r *Resolver) Preferred() []Resource {
+	var res []Resource
+	seen := map[schema.GroupResource]bool{}
+	for _, rs := range r.resources {
+		if !seen[rs.GroupResource()] {
+			seen[rs.GroupResource()] = true
+			res = append(res, rs)
+		}
+	}
+	return res
+}
+
+// Resolve finds the resource for what users type: plural, singular, short name or Kind, optionally followed
+// by .group or .version.group. without a group, the first group serving the name wins, core first,
+// the way kubectl picks `events` over `events.events.k8s.io`
+func (r *Resolver) Resolve(input string) (Resource, error) {
+	if input == "" {
+		return Resource{}, fmt.Errorf("you must specify the type of resource")
+	}
+	name := strings.ToLower(input)
+	var gvr *schema.GroupVersionResource
+	gr := schema.GroupResource{Resource: name}
+	if strings.Contains(name, ".") {
+		gvr, gr = schema.ParseResourceArg(name)
+	}
+	if gvr != nil {
+		if res, ok := r.find(gvr.Resource, func(rs Resource) bool {
+			return rs.Group == gvr.Group && rs.Version == gvr.Version
+		}); ok {
+			return res, nil
+		}
+	}
+	if strings.Contains(name, ".") {
+		// resource.group, or resource.version for the core group
+		if res, ok := r.find(gr.Resource, func(rs Resource) bool {
+			return rs.Group == gr.Group || (rs.Group == "" && rs.Version == gr.Group)
+		}); ok {
+			return res, nil
+		}
+	} else if res, ok := r.find(name, func(Resource) bool { return true }); ok {
+		return res, nil
+	}
+	return Resource{}, fmt.Errorf("the server doesn't have a resource type \"%s\"", input)
+}
+
+// find returns the first resource accepted by 'filter' that has 'name' as plural, then as singular,
+// then as short name, then as Kind
+func (r *Resolver) find(name string, filter func(Resource) bool) (Resource, bool) {
+	matchers := []func(Resource) bool{
+		func(rs Resource) bool { return rs.Resource == name },
+		func(rs Resource) bool { return rs.SingularName == name },
+		func(rs Resource) bool {
+			for _, sn := range rs.ShortNames {
+				if sn == name {
+					return true
+				}
+			}
+			return false
+		},
+		func(rs Resource) bool { return strings.ToLower(rs.Kind) == name },
+	}
+	for _, match := range matchers {
+		for _, rs := range r.resources {
+			if filter(rs) && match(rs) {
+				return rs, true
+			}
+		}
+	}
+	return Resource{}, false
+}
+
+// Add makes the resolver aware of more resources, e.g. the ones of a CRD that was just created
+func (r *Resolver) Add(resources ...Resource) {
+	r.resources = append(r.resources, resources...)
+}
+
+// CRDResources returns the resources a CustomResourceDefinition serves, one per served version
+func CRDResources(crd *unstructured.Unstructured) []Resource {
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+	singular, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "singular")
+	kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	shortNames, _, _ := unstructured.NestedStringSlice(crd.Object, "spec", "names", "shortNames")
+	scope, _, _ := unstructured.NestedString(crd.Object, "spec", "scope")
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if singular == "" {
+		singular = strings.ToLower(kind)
+	}
+	var res []Resource
+	for _, v := range versions {
+		version, _ := v.(map[string]interface{})
+		name, _ := version["name"].(string)
+		if served, _ := version["served"].(bool); !served || name == "" {
+			continue
+		}
+		res = append(res, Resource{
+			GroupVersionResource: schema.GroupVersionResource{Group: group, Version: name, Resource: plural},
+			Kind:                 kind,
+			SingularName:         singular,
+			ShortNames:           shortNames,
+			Namespaced:           scope == "Namespaced",
+		})
+	}
+	return res
+}
+
+// ForKind returns the resource serving objects of 'gvk'
+func (r *Resolver) ForKind(gvk schema.GroupVersionKind) (Resource, error) {
+	for _, rs := range r.resources {
+		if rs.GroupVersionKind() == gvk {
+			return rs, nil
+		}
+	}
+	return Resource{}, fmt.Errorf("no resource of kind \"%s\" in version \"%s\"", gvk.Kind, gvk.GroupVersion())
+}
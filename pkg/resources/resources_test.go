@@ -0,0 +1,118 @@
+package resources
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestResolve(t *testing.T) {
+	r := Builtin()
+	cases := []struct {
+		input      string
+		want       schema.GroupVersionResource
+		namespaced bool
+	}{
+		{"pods", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, true},
+		{"pod", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, true},
+		{"po", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, true},
+		{"Pod", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, true},
+		{"ns", schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, false},
+		{"crd", schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}, false},
+		{"crds", schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}, false},
+		{"events", schema.GroupVersionResource{Version: "v1", Resource: "events"}, true},
+		{"ev", schema.GroupVersionResource{Version: "v1", Resource: "events"}, true},
+		{"events.events.k8s.io", schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}, true},
+		{"event.events.k8s.io", schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}, true},
+		{"deployments.apps", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, true},
+		{"Deployment.apps", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, true},
+		{"deploy", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, true},
+		{"deployments.v1.apps", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, true},
+		{"pods.v1", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, true},
+		{"clusterroles.rbac.authorization.k8s.io", schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}, false},
+	}
+	for _, c := range cases {
+		have, err := r.Resolve(c.input)
+		if err != nil {
+			t.Errorf("test case '%s' failed. error: %v", c.input, err)
+			continue
+		}
+		if have.GroupVersionResource != c.want || have.Namespaced != c.namespaced {
+			t.Errorf("test case '%s' failed. want: '%v' namespaced %v have: '%v' namespaced %v", c.input, c.want, c.namespaced, have.GroupVersionResource, have.Namespaced)
+		}
+	}
+
+	for _, input := range []string{"", "foos", "deployments.v2.apps", "deployments.batch", "po.apps"} {
+		if _, err := r.Resolve(input); err == nil {
+			t.Errorf("test case '%s' failed. want an error", input)
+		}
+	}
+}
+
+func TestParseAPIResources(t *testing.T) {
+	in := `NAME          SHORTNAMES   APIVERSION   NAMESPACED   KIND         VERBS
+configmaps    cm           v1           true         ConfigMap    [create delete get list]
+deployments   deploy,dp    apps/v1      true         Deployment   [get list]
+nodes                      v1           false        Node         [get]
+`
+	have, err := ParseAPIResources(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+	want := []Resource{
+		{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, Kind: "ConfigMap", SingularName: "configmap", ShortNames: []string{"cm"}, Namespaced: true, Verbs: []string{"create", "delete", "get", "list"}},
+		{GroupVersionResource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, Kind: "Deployment", SingularName: "deployment", ShortNames: []string{"deploy", "dp"}, Namespaced: true, Verbs: []string{"get", "list"}},
+		{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, Kind: "Node", SingularName: "node", Verbs: []string{"get"}},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("want: '%+v' have: '%+v'", want, have)
+	}
+	if !have[1].HasVerbs("get", "list") || have[2].HasVerbs("get", "list") {
+		t.Errorf("HasVerbs failed")
+	}
+
+	if _, err := ParseAPIResources(strings.NewReader("pods v1 Pod\n")); err == nil {
+		t.Errorf("want an error for a malformed line")
+	}
+}
+
+func TestFromDiscovery(t *testing.T) {
+	d := &discoveryfake.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	d.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", SingularName: "pod", ShortNames: []string{"po"}, Namespaced: true, Kind: "Pod", Verbs: []string{"get", "list"}},
+				{Name: "pods/status", Namespaced: true, Kind: "Pod"},
+			},
+		},
+		{
+			GroupVersion: "example.com/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "widgets", SingularName: "widget", ShortNames: []string{"wd", "wdg"}, Namespaced: false, Kind: "Widget", Verbs: []string{"get"}},
+			},
+		},
+	}
+	r, err := FromDiscovery(d)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(r.Resources()) != 2 {
+		t.Errorf("subresources should be left out, have: %+v", r.Resources())
+	}
+	have, err := r.Resolve("wdg")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if have.Group != "example.com" || have.Namespaced || have.Kind != "Widget" {
+		t.Errorf("want: widgets.example.com have: '%+v'", have)
+	}
+	if _, err := r.ForKind(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}); err != nil {
+		t.Errorf("ForKind failed: %v", err)
+	}
+}
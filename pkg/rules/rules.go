@@ -0,0 +1,382 @@
+// Package rules implements the declarative neating rule engine.
+// A rule selects objects by group/version/kind and an optional label selector,
+// and then deletes, keeps or rewrites fields addressed by gjson paths.
+package rules
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/paths"
+	"github.com/ghodss/yaml"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+//go:embed builtin.yaml
+var builtinRules []byte
+
+// RuleSet is an ordered list of rules, as found in a rules file.
+type RuleSet struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule describes the fields to clean up for the objects it matches.
+type Rule struct {
+	Name  string `json:"name"`
+	Match Match  `json:"match,omitempty"`
+	// Delete lists paths to remove from the object.
+	Delete []string `json:"delete,omitempty"`
+	// Keep lists paths that must survive Delete, including deletes of other rules.
+	Keep []string `json:"keep,omitempty"`
+	// Rewrite lists in place changes to existing fields.
+	Rewrite []Rewrite `json:"rewrite,omitempty"`
+}
+
+// Match selects the objects a rule applies to. Empty fields and "*" match anything.
+type Match struct {
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	Selector string `json:"selector,omitempty"`
+	// FieldSelector matches the values of fields, addressed by gjson paths, e.g. spec.clusterIP=None
+	FieldSelector string `json:"fieldSelector,omitempty"`
+
+	selector      labels.Selector
+	fieldSelector fields.Selector
+}
+
+// Rewrite changes the value of an existing field.
+// With Pattern set, the regular expression is replaced by Replacement in the string value,
+// otherwise the value is replaced by Value.
+type Rewrite struct {
+	Path        string      `json:"path"`
+	Value       interface{} `json:"value,omitempty"`
+	Pattern     string      `json:"pattern,omitempty"`
+	Replacement string      `json:"replacement,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Builtin returns the rule set embedded in the binary
+func Builtin() *RuleSet {
+	rs, err := Load(builtinRules)
+	if err != nil {
+		panic(fmt.Sprintf("error loading builtin rules : %v", err))
+	}
+	return rs
+}
+
+// LoadFile reads a YAML or JSON rules file
+func LoadFile(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("error loading rules from %s : %v", path, err)
+	}
+	return rs, nil
+}
+
+// Load parses a YAML or JSON rules document and validates it
+func Load(data []byte) (*RuleSet, error) {
+	var rs RuleSet
+	if err := yaml.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("error parsing rules : %v", err)
+	}
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if r.Match.Selector != "" {
+			sel, err := labels.Parse(r.Match.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("error in rule '%s' selector : %v", r.Name, err)
+			}
+			r.Match.selector = sel
+		}
+		if r.Match.FieldSelector != "" {
+			sel, err := fields.ParseSelector(r.Match.FieldSelector)
+			if err != nil {
+				return nil, fmt.Errorf("error in rule '%s' field selector : %v", r.Name, err)
+			}
+			r.Match.fieldSelector = sel
+		}
+		for j := range r.Rewrite {
+			rw := &r.Rewrite[j]
+			if rw.Path == "" {
+				return nil, fmt.Errorf("error in rule '%s' : rewrite without path", r.Name)
+			}
+			if rw.Pattern != "" {
+				re, err := regexp.Compile(rw.Pattern)
+				if err != nil {
+					return nil, fmt.Errorf("error in rule '%s' rewrite pattern : %v", r.Name, err)
+				}
+				rw.re = re
+			}
+		}
+	}
+	return &rs, nil
+}
+
+// Merge returns a rule set with the rules of 'other' appended to the rules of 'rs'
+func (rs *RuleSet) Merge(other *RuleSet) *RuleSet {
+	res := &RuleSet{}
+	res.Rules = append(res.Rules, rs.Rules...)
+	res.Rules = append(res.Rules, other.Rules...)
+	return res
+}
+
+// Matches reports whether the rule applies to the object
+func (m Match) Matches(obj string) bool {
+	gv, err := schema.ParseGroupVersion(gjson.Get(obj, "apiVersion").String())
+	if err != nil {
+		return false
+	}
+	if !matchField(m.Group, gv.Group) || !matchField(m.Version, gv.Version) || !matchField(m.Kind, gjson.Get(obj, "kind").String()) {
+		return false
+	}
+	if m.selector != nil {
+		set := labels.Set{}
+		gjson.Get(obj, "metadata.labels").ForEach(func(k, v gjson.Result) bool {
+			set[k.String()] = v.String()
+			return true
+		})
+		if !m.selector.Matches(set) {
+			return false
+		}
+	}
+	if m.fieldSelector != nil {
+		set := fields.Set{}
+		for _, r := range m.fieldSelector.Requirements() {
+			set[r.Field] = gjson.Get(obj, r.Field).String()
+		}
+		if !m.fieldSelector.Matches(set) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchField(want, have string) bool {
+	return want == "" || want == "*" || want == have
+}
+
+// Apply runs every rule matching the object, in order, and returns the result
+func (rs *RuleSet) Apply(in string) (string, error) {
+	var err error
+	var matched []Rule
+	var keep []string
+	for _, r := range rs.Rules {
+		if r.Match.Matches(in) {
+			matched = append(matched, r)
+			keep = append(keep, r.Keep...)
+		}
+	}
+	draft := in
+	for _, r := range matched {
+		for _, p := range r.Delete {
+			draft, err = deletePath(draft, p, keep)
+			if err != nil {
+				return in, fmt.Errorf("error in rule '%s' deleting '%s' : %v", r.Name, p, err)
+			}
+		}
+		for _, rw := range r.Rewrite {
+			draft, err = rewritePath(draft, rw)
+			if err != nil {
+				return in, fmt.Errorf("error in rule '%s' rewriting '%s' : %v", r.Name, rw.Path, err)
+			}
+		}
+	}
+	return draft, nil
+}
+
+// deletePath deletes every field addressed by 'pattern', sparing the fields addressed by 'keep'.
+// the arrays and objects left empty by the deletes are deleted too, up to the top level fields
+func deletePath(in string, pattern string, keep []string) (string, error) {
+	var err error
+	var kept []string
+	for _, k := range keep {
+		kept = append(kept, Expand(in, k)...)
+	}
+	paths := Expand(in, pattern)
+	// delete from the end so that array indexes of the remaining paths stay valid
+	for i := len(paths) - 1; i >= 0; i-- {
+		in, err = deleteUnlessKept(in, paths[i], kept)
+		if err != nil {
+			return in, err
+		}
+	}
+	for i := len(paths) - 1; i >= 0; i-- {
+		in, err = deleteEmptiedParents(in, paths[i], kept)
+		if err != nil {
+			return in, err
+		}
+	}
+	return in, nil
+}
+
+// deleteEmptiedParents walks up from a deleted path and deletes the parents it left empty.
+// top level fields, e.g. spec or metadata, stay
+func deleteEmptiedParents(in string, path string, kept []string) (string, error) {
+	var err error
+	for parent, _ := parentPath(path); len(splitPath(parent)) > 1; parent, _ = parentPath(parent) {
+		cur := gjson.Get(in, parent)
+		if !(cur.IsArray() && len(cur.Array()) == 0) && !(cur.IsObject() && len(cur.Map()) == 0) {
+			break
+		}
+		in, err = deleteUnlessKept(in, parent, kept)
+		if err != nil {
+			return in, err
+		}
+	}
+	return in, nil
+}
+
+// parentPath splits the last segment off a concrete path
+func parentPath(path string) (string, string) {
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return "", ""
+	}
+	return strings.Join(segments[:len(segments)-1], "."), segments[len(segments)-1]
+}
+
+// deleteUnlessKept deletes 'path' if it's not kept. When a kept field lives under 'path',
+// only the siblings on the way down to it are deleted.
+func deleteUnlessKept(in string, path string, kept []string) (string, error) {
+	var err error
+	var within bool
+	for _, k := range kept {
+		if k == path || strings.HasPrefix(path, k+".") {
+			return in, nil
+		}
+		if strings.HasPrefix(k, path+".") {
+			within = true
+		}
+	}
+	if !within {
+		return sjson.Delete(in, path)
+	}
+	var children []string
+	cur := gjson.Get(in, path)
+	index := -1
+	cur.ForEach(func(k, _ gjson.Result) bool {
+		if cur.IsArray() {
+			index++
+			children = append(children, path+"."+strconv.Itoa(index))
+		} else {
+			children = append(children, path+"."+paths.Escape(k.String()))
+		}
+		return true
+	})
+	for i := len(children) - 1; i >= 0; i-- {
+		in, err = deleteUnlessKept(in, children[i], kept)
+		if err != nil {
+			return in, err
+		}
+	}
+	return in, nil
+}
+
+func rewritePath(in string, rw Rewrite) (string, error) {
+	var err error
+	for _, p := range Expand(in, rw.Path) {
+		if rw.re != nil {
+			cur := gjson.Get(in, p)
+			if cur.Type != gjson.String {
+				continue
+			}
+			in, err = sjson.Set(in, p, rw.re.ReplaceAllString(cur.String(), rw.Replacement))
+		} else {
+			in, err = sjson.Set(in, p, rw.Value)
+		}
+		if err != nil {
+			return in, err
+		}
+	}
+	return in, nil
+}
+
+// Expand resolves a rule path against a json document and returns the concrete paths of the existing fields it addresses.
+// Besides plain gjson paths, a '#' segment stands for every element of an array,
+// and a '#(query)' segment for the elements matching the gjson query, e.g. `spec.volumes.#(name%"default-token-*")`.
+func Expand(doc string, path string) []string {
+	var res []string
+	expandRecursive(doc, "", splitPath(path), &res)
+	return res
+}
+
+func expandRecursive(doc string, prefix string, segments []string, res *[]string) {
+	if len(segments) == 0 {
+		if prefix != "" && gjson.Get(doc, prefix).Exists() {
+			*res = append(*res, prefix)
+		}
+		return
+	}
+	seg := segments[0]
+	if seg != "#" && !strings.HasPrefix(seg, "#(") {
+		expandRecursive(doc, join(prefix, seg), segments[1:], res)
+		return
+	}
+	arr := gjson.Get(doc, prefix)
+	if prefix == "" {
+		arr = gjson.Parse(doc)
+	}
+	if !arr.IsArray() {
+		return
+	}
+	for i, elem := range arr.Array() {
+		if seg != "#" && !gjson.Get("["+elem.Raw+"]", seg).Exists() {
+			continue
+		}
+		expandRecursive(doc, join(prefix, strconv.Itoa(i)), segments[1:], res)
+	}
+}
+
+// splitPath splits a gjson path on dots, honoring '\' escapes and dots inside queries
+func splitPath(path string) []string {
+	var res []string
+	var cur strings.Builder
+	depth := 0
+	quoted := false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\' && i+1 < len(path):
+			cur.WriteByte(c)
+			i++
+			cur.WriteByte(path[i])
+			continue
+		case c == '"':
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted:
+			depth--
+		case c == '.' && depth == 0 && !quoted:
+			res = append(res, cur.String())
+			cur.Reset()
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	if cur.Len() > 0 {
+		res = append(res, cur.String())
+	}
+	return res
+}
+
+func join(prefix, seg string) string {
+	if prefix == "" {
+		return seg
+	}
+	return prefix + "." + seg
+}
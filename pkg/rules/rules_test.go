@@ -0,0 +1,255 @@
+package rules
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+)
+
+func TestExpand(t *testing.T) {
+	doc := `{
+		"spec": {
+			"containers": [
+				{"name": "a", "volumeMounts": [{"name": "default-token-1"}, {"name": "data"}]},
+				{"name": "b", "volumeMounts": [{"name": "default-token-2"}]}
+			]
+		},
+		"metadata": {"annotations": {"example.com/foo": "bar"}}
+	}`
+	cases := []struct {
+		title  string
+		path   string
+		expect []string
+	}{
+		{
+			title:  "plain",
+			path:   "spec.containers",
+			expect: []string{"spec.containers"},
+		},
+		{
+			title:  "missing",
+			path:   "spec.volumes",
+			expect: nil,
+		},
+		{
+			title:  "escaped",
+			path:   `metadata.annotations.example\.com/foo`,
+			expect: []string{`metadata.annotations.example\.com/foo`},
+		},
+		{
+			title:  "all elements",
+			path:   "spec.containers.#.name",
+			expect: []string{"spec.containers.0.name", "spec.containers.1.name"},
+		},
+		{
+			title:  "query",
+			path:   `spec.containers.#.volumeMounts.#(name%"default-token-*")`,
+			expect: []string{"spec.containers.0.volumeMounts.0", "spec.containers.1.volumeMounts.0"},
+		},
+	}
+	for _, c := range cases {
+		res := Expand(doc, c.path)
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf("test case '%s' failed. want: '%v' have: '%v'", c.title, c.expect, res)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	cases := []struct {
+		title  string
+		rules  string
+		data   string
+		expect string
+	}{
+		{
+			title: "delete by kind",
+			rules: `
+rules:
+- name: svc
+  match:
+    kind: Service
+  delete:
+  - spec.clusterIP`,
+			data:   `{"apiVersion": "v1", "kind": "Service", "spec": {"clusterIP": "10.0.0.1", "type": "ClusterIP"}}`,
+			expect: `{"apiVersion": "v1", "kind": "Service", "spec": {"type": "ClusterIP"}}`,
+		},
+		{
+			title: "no match by group",
+			rules: `
+rules:
+- name: deploy
+  match:
+    group: apps
+  delete:
+  - spec.replicas`,
+			data:   `{"apiVersion": "v1", "kind": "ReplicationController", "spec": {"replicas": 1}}`,
+			expect: `{"apiVersion": "v1", "kind": "ReplicationController", "spec": {"replicas": 1}}`,
+		},
+		{
+			title: "selector",
+			rules: `
+rules:
+- name: managed
+  match:
+    selector: team in (a,b)
+  delete:
+  - spec.replicas`,
+			data:   `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"labels": {"team": "a"}}, "spec": {"replicas": 1}}`,
+			expect: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"labels": {"team": "a"}}, "spec": {}}`,
+		},
+		{
+			title: "keep overrides delete of another rule",
+			rules: `
+rules:
+- name: annotations
+  delete:
+  - metadata.annotations
+- name: keep-owner
+  match:
+    kind: ConfigMap
+  keep:
+  - metadata.annotations.owner`,
+			data:   `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"annotations": {"owner": "me", "noise": "x"}}}`,
+			expect: `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"annotations": {"owner": "me"}}}`,
+		},
+		{
+			title: "delete array elements",
+			rules: `
+rules:
+- name: tokens
+  delete:
+  - spec.volumes.#(name%"default-token-*")`,
+			data:   `{"apiVersion": "v1", "kind": "Pod", "spec": {"volumes": [{"name": "default-token-a"}, {"name": "data"}, {"name": "default-token-b"}]}}`,
+			expect: `{"apiVersion": "v1", "kind": "Pod", "spec": {"volumes": [{"name": "data"}]}}`,
+		},
+		{
+			title: "field selector",
+			rules: `
+rules:
+- name: svc
+  match:
+    kind: Service
+  delete:
+  - spec.clusterIP
+- name: headless
+  match:
+    kind: Service
+    fieldSelector: spec.clusterIP=None,spec.type!=NodePort
+  keep:
+  - spec.clusterIP`,
+			data:   `{"apiVersion": "v1", "kind": "Service", "spec": {"clusterIP": "None", "type": "ClusterIP"}}`,
+			expect: `{"apiVersion": "v1", "kind": "Service", "spec": {"clusterIP": "None", "type": "ClusterIP"}}`,
+		},
+		{
+			title: "field selector without match",
+			rules: `
+rules:
+- name: svc
+  delete:
+  - spec.clusterIP
+- name: headless
+  match:
+    fieldSelector: spec.clusterIP=None
+  keep:
+  - spec.clusterIP`,
+			data:   `{"apiVersion": "v1", "kind": "Service", "spec": {"clusterIP": "10.0.0.1"}}`,
+			expect: `{"apiVersion": "v1", "kind": "Service", "spec": {}}`,
+		},
+		{
+			title: "delete the array emptied",
+			rules: `
+rules:
+- name: tokens
+  delete:
+  - spec.volumes.#(name%"default-token-*")
+  - spec.containers.#.volumeMounts.#(name%"default-token-*")`,
+			data:   `{"apiVersion": "v1", "kind": "Pod", "spec": {"volumes": [{"name": "default-token-a"}], "containers": [{"name": "a", "volumeMounts": [{"name": "default-token-a"}]}, {"name": "b", "volumeMounts": [{"name": "data"}]}]}}`,
+			expect: `{"apiVersion": "v1", "kind": "Pod", "spec": {"containers": [{"name": "a"}, {"name": "b", "volumeMounts": [{"name": "data"}]}]}}`,
+		},
+		{
+			title: "delete the objects emptied",
+			rules: `
+rules:
+- name: rollout
+  delete:
+  - metadata.annotations.deployment\.kubernetes\.io/revision
+  - spec.template.metadata.creationTimestamp
+  - spec.template.spec.containers.#.resources.limits.cpu`,
+			data: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "annotations": {"deployment.kubernetes.io/revision": "3"}},
+				"spec": {"template": {"metadata": {"creationTimestamp": null}, "spec": {"containers": [{"name": "a", "resources": {"limits": {"cpu": "1"}}}, {"name": "b", "resources": {"limits": {"cpu": "1", "memory": "1Gi"}}}]}}}}`,
+			expect: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web"},
+				"spec": {"template": {"spec": {"containers": [{"name": "a"}, {"name": "b", "resources": {"limits": {"memory": "1Gi"}}}]}}}}`,
+		},
+		{
+			title: "rewrite",
+			rules: `
+rules:
+- name: registry
+  rewrite:
+  - path: spec.containers.#.image
+    pattern: ^docker\.io/
+    replacement: registry.local/
+  - path: spec.replicas
+    value: 1`,
+			data:   `{"apiVersion": "v1", "kind": "Pod", "spec": {"replicas": 3, "containers": [{"image": "docker.io/nginx"}, {"image": "quay.io/foo"}]}}`,
+			expect: `{"apiVersion": "v1", "kind": "Pod", "spec": {"replicas": 1, "containers": [{"image": "registry.local/nginx"}, {"image": "quay.io/foo"}]}}`,
+		},
+	}
+	for _, c := range cases {
+		rs, err := Load([]byte(c.rules))
+		if err != nil {
+			t.Errorf("error in Load for case '%s': %v", c.title, err)
+			continue
+		}
+		resJSON, err := rs.Apply(c.data)
+		if err != nil {
+			t.Errorf("error in Apply for case '%s': %v", c.title, err)
+			continue
+		}
+		equal, err := testutil.JSONEqual(resJSON, c.expect)
+		if err != nil {
+			t.Errorf("error in JSONEqual for case '%s': %v", c.title, err)
+			continue
+		}
+		if !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, resJSON)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	cases := []struct {
+		title string
+		rules string
+	}{
+		{
+			title: "bad selector",
+			rules: "rules:\n- name: x\n  match:\n    selector: 'a in ('",
+		},
+		{
+			title: "bad field selector",
+			rules: "rules:\n- name: x\n  match:\n    fieldSelector: 'a'",
+		},
+		{
+			title: "bad pattern",
+			rules: "rules:\n- name: x\n  rewrite:\n  - path: a\n    pattern: '('",
+		},
+		{
+			title: "rewrite without path",
+			rules: "rules:\n- name: x\n  rewrite:\n  - value: 1",
+		},
+	}
+	for _, c := range cases {
+		if _, err := Load([]byte(c.rules)); err == nil {
+			t.Errorf("test case '%s' failed. want error, have nil", c.title)
+		}
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	if len(Builtin().Rules) == 0 {
+		t.Errorf("builtin rules are empty")
+	}
+}
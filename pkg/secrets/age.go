@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// values are encrypted to age X25519 recipients (https://age-encryption.org/v1) and armored,
+// so they can be decrypted with `age -d -i key.txt` and no key server is needed
+
+// ParseRecipient parses an age1... public key, as printed by age-keygen
+func ParseRecipient(s string) (*age.X25519Recipient, error) {
+	r, err := age.ParseX25519Recipient(s)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing age recipient %s : %v", s, err)
+	}
+	return r, nil
+}
+
+// encrypt encrypts 'plaintext' to the recipients as an armored age file
+func encrypt(plaintext []byte, recipients []*age.X25519Recipient) (string, error) {
+	var out bytes.Buffer
+	a := armor.NewWriter(&out)
+	var rs []age.Recipient
+	for _, r := range recipients {
+		rs = append(rs, r)
+	}
+	w, err := age.Encrypt(a, rs...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(w, bytes.NewReader(plaintext)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := a.Close(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,125 @@
+// Package secrets decides what happens to the values of Secrets when they're written out:
+// kept, redacted, omitted or encrypted with age.
+package secrets
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"filippo.io/age"
+	"github.com/Baiyuani/kubectl-neatx/pkg/paths"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Mode is what a Policy does to Secrets
+type Mode string
+
+const (
+	// Keep leaves Secrets as they are
+	Keep Mode = "keep"
+	// Redact replaces the values with a placeholder, the keys stay
+	Redact Mode = "redact"
+	// Omit drops Secrets
+	Omit Mode = "omit"
+	// Encrypt encrypts the values to age recipients
+	Encrypt Mode = "encrypt"
+)
+
+// Annotation tells how the values of a Secret were changed: redacted or age
+const Annotation = "kubectl-neatx.io/secrets"
+
+// RedactedValue replaces the values of redacted Secrets, base64 encoded in data so the Secret stays valid
+const RedactedValue = "REDACTED"
+
+// Policy applies a Mode to Secrets
+type Policy struct {
+	Mode       Mode
+	Recipients []*age.X25519Recipient
+}
+
+// NewPolicy builds a policy from the mode name and, to encrypt, the age1... recipients
+func NewPolicy(mode string, recipients []string) (*Policy, error) {
+	p := &Policy{Mode: Mode(mode)}
+	switch p.Mode {
+	case Keep, Redact, Omit:
+	case Encrypt:
+		if len(recipients) == 0 {
+			return nil, fmt.Errorf("encrypting secrets needs at least one age recipient")
+		}
+	default:
+		return nil, fmt.Errorf("unknown secrets mode '%s', want keep, redact, omit or encrypt", mode)
+	}
+	for _, r := range recipients {
+		recipient, err := ParseRecipient(r)
+		if err != nil {
+			return nil, err
+		}
+		p.Recipients = append(p.Recipients, recipient)
+	}
+	return p, nil
+}
+
+// Apply applies the policy to a json object. objects other than v1 Secrets are returned as they are.
+// the boolean is false when the object is omitted
+func (p *Policy) Apply(in string) (string, bool, error) {
+	if p == nil || p.Mode == Keep || gjson.Get(in, "kind").String() != "Secret" || gjson.Get(in, "apiVersion").String() != "v1" {
+		return in, true, nil
+	}
+	switch p.Mode {
+	case Omit:
+		return "", false, nil
+	case Redact:
+		out, err := p.replaceValues(in, func(string, []byte) (string, error) {
+			return RedactedValue, nil
+		})
+		if err != nil {
+			return in, true, err
+		}
+		out, err = sjson.Set(out, "metadata.annotations."+paths.Escape(Annotation), "redacted")
+		return out, true, err
+	case Encrypt:
+		out, err := p.replaceValues(in, func(key string, value []byte) (string, error) {
+			return encrypt(value, p.Recipients)
+		})
+		if err != nil {
+			return in, true, err
+		}
+		out, err = sjson.Set(out, "metadata.annotations."+paths.Escape(Annotation), "age")
+		return out, true, err
+	}
+	return in, true, nil
+}
+
+// replaceValues replaces the values of data and stringData with what 'replace' makes of their plain value.
+// data values stay base64 encoded, so the Secret stays valid
+func (p *Policy) replaceValues(in string, replace func(key string, value []byte) (string, error)) (string, error) {
+	out := in
+	for _, field := range []string{"data", "stringData"} {
+		var err error
+		gjson.Get(in, field).ForEach(func(k, v gjson.Result) bool {
+			value := []byte(v.String())
+			if field == "data" {
+				value, err = base64.StdEncoding.DecodeString(v.String())
+				if err != nil {
+					err = fmt.Errorf("error decoding %s.%s : %v", field, k.String(), err)
+					return false
+				}
+			}
+			var replaced string
+			replaced, err = replace(k.String(), value)
+			if err != nil {
+				return false
+			}
+			if field == "data" {
+				replaced = base64.StdEncoding.EncodeToString([]byte(replaced))
+			}
+			out, err = sjson.Set(out, field+"."+paths.Escape(k.String()), replaced)
+			return err == nil
+		})
+		if err != nil {
+			return in, err
+		}
+	}
+	return out, nil
+}
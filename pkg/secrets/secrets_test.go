@@ -0,0 +1,137 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/Baiyuani/kubectl-neatx/pkg/testutil"
+	"github.com/tidwall/gjson"
+)
+
+// decrypt decrypts an armored age file with an X25519 identity
+func decrypt(armored string, identity *age.X25519Identity) ([]byte, error) {
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(armored)), identity)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func testIdentity(t *testing.T) (*age.X25519Identity, string) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity, identity.Recipient().String()
+}
+
+func TestParseRecipient(t *testing.T) {
+	// the example key of the age README
+	key := "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+	r, err := ParseRecipient(key)
+	if err != nil {
+		t.Fatalf("error parsing %s: %v", key, err)
+	}
+	if r.String() != key {
+		t.Errorf("want: '%s' have: '%s'", key, r.String())
+	}
+	for _, bad := range []string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8q", "npub1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", "age1"} {
+		if _, err := ParseRecipient(bad); err == nil {
+			t.Errorf("want error parsing %s", bad)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	secret := `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "db", "namespace": "app1"}, "type": "Opaque", "data": {"password": "czNjcjN0", "tls.key": "a2V5"}, "stringData": {"user": "admin"}}`
+	cm := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "db"}, "data": {"password": "not a secret"}}`
+	cases := []struct {
+		title  string
+		mode   string
+		data   string
+		expect string
+		kept   bool
+	}{
+		{"keep", "keep", secret, secret, true},
+		{"omit", "omit", secret, "", false},
+		{"omit config map", "omit", cm, cm, true},
+		{"redact config map", "redact", cm, cm, true},
+		{
+			title: "redact",
+			mode:  "redact",
+			data:  secret,
+			expect: `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "db", "namespace": "app1", "annotations": {"kubectl-neatx.io/secrets": "redacted"}}, "type": "Opaque",
+				"data": {"password": "UkVEQUNURUQ=", "tls.key": "UkVEQUNURUQ="}, "stringData": {"user": "REDACTED"}}`,
+			kept: true,
+		},
+	}
+	for _, c := range cases {
+		p, err := NewPolicy(c.mode, nil)
+		if err != nil {
+			t.Errorf("test case '%s' failed. error: %v", c.title, err)
+			continue
+		}
+		res, kept, err := p.Apply(c.data)
+		if err != nil || kept != c.kept {
+			t.Errorf("test case '%s' failed. kept: %v error: %v", c.title, kept, err)
+			continue
+		}
+		if c.expect == "" {
+			if res != "" {
+				t.Errorf("test case '%s' failed. want: '' have: '%s'", c.title, res)
+			}
+			continue
+		}
+		equal, err := testutil.JSONEqual(res, c.expect)
+		if err != nil || !equal {
+			t.Errorf("test case '%s' failed. want: '%s' have: '%s'", c.title, c.expect, res)
+		}
+	}
+}
+
+func TestApplyEncrypt(t *testing.T) {
+	identity1, recipient1 := testIdentity(t)
+	identity2, recipient2 := testIdentity(t)
+	p, err := NewPolicy("encrypt", []string{recipient1, recipient2})
+	if err != nil {
+		t.Fatalf("error building policy: %v", err)
+	}
+	secret := `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "db"}, "data": {"password": "czNjcjN0", "empty": ""}, "stringData": {"user": "admin"}}`
+	res, kept, err := p.Apply(secret)
+	if err != nil || !kept {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if gjson.Get(res, "metadata.annotations.kubectl-neatx\\.io/secrets").String() != "age" {
+		t.Errorf("missing annotation: %s", res)
+	}
+	for path, want := range map[string]string{"data.password": "s3cr3t", "data.empty": "", "stringData.user": "admin"} {
+		armored := gjson.Get(res, path).String()
+		if strings.HasPrefix(path, "data.") {
+			decoded, err := base64.StdEncoding.DecodeString(armored)
+			if err != nil {
+				t.Errorf("%s not base64 encoded: %v", path, err)
+			}
+			armored = string(decoded)
+		}
+		if !strings.HasPrefix(armored, "-----BEGIN AGE ENCRYPTED FILE-----\n") {
+			t.Errorf("%s not armored: %s", path, armored)
+		}
+		for _, identity := range []*age.X25519Identity{identity1, identity2} {
+			plain, err := decrypt(armored, identity)
+			if err != nil || string(plain) != want {
+				t.Errorf("%s: want: '%s' have: '%s' error: %v", path, want, plain, err)
+			}
+		}
+	}
+
+	if _, err := NewPolicy("encrypt", nil); err == nil {
+		t.Errorf("want error for encryption without recipients")
+	}
+	if _, err := NewPolicy("shred", nil); err == nil {
+		t.Errorf("want error for an unknown mode")
+	}
+}
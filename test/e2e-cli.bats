@@ -15,7 +15,7 @@ rootDir="./test/fixtures"
 @test "invalid args 2" {
     run2 "$exe" get --foo
     [ $status -eq 1 ]
-    [[ "$stderr" == "Error: Error invoking kubectl"* ]]
+    [[ "$stderr" == "Error: you must specify the type of resource"* ]]
 }
 
 @test "invalid args 3" {